@@ -22,7 +22,7 @@ func main() {
 		})
 	*/
 
-	srv, _ := sio.NewServer()
+	srv, _ := sio.NewServer(sio.ServerOptions{})
 
 	srv.Of("/").OnConnect = func(socket *sio.Socket, next sio.NamespaceMiddleware) sio.NamespaceMiddleware {
 		log.Println(socket)
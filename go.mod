@@ -4,6 +4,6 @@ go 1.13
 
 require (
 	github.com/gorilla/websocket v1.4.1
-	github.com/json-iterator/go v1.1.9
+	github.com/json-iterator/go v1.1.12
 	github.com/stretchr/testify v1.4.0 // indirect
 )
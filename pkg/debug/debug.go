@@ -0,0 +1,162 @@
+// Package debug provides the logger used by eio and sio.
+//
+// By default nothing is logged. Like the debug module of node, the DEBUG environment variable enables logging
+// to stderr for a comma or space separated list of names, * matches any characters and a leading - excludes:
+//
+//	DEBUG=goseio:*                 everything
+//	DEBUG=goseio:engine,goseio:socket
+//	DEBUG=goseio:*,-goseio:engine
+package debug
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger logs messages with key value pairs, e.g. logger.Debug("closed", "sid", id).
+// It is satisfied by *slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// Nop discards everything.
+var Nop Logger = nopLogger{}
+
+// New returns a logger writing to stderr if name is enabled by the DEBUG environment variable, Nop otherwise.
+func New(name string) Logger {
+	if !Enabled(os.Getenv("DEBUG"), name) {
+		return Nop
+	}
+	return NewWriter(name, os.Stderr)
+}
+
+// Named returns l with the field logger=name, or New(name) if l is nil.
+func Named(l Logger, name string) Logger {
+	if l == nil {
+		return New(name)
+	}
+	return With(l, "logger", name)
+}
+
+// Enabled reports whether name matches the DEBUG style list of patterns.
+func Enabled(patterns string, name string) bool {
+	enabled := false
+	for _, pattern := range strings.FieldsFunc(patterns, func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		if pattern[0] == '-' {
+			if match(pattern[1:], name) {
+				return false
+			}
+		} else if match(pattern, name) {
+			enabled = true
+		}
+	}
+	return enabled
+}
+
+// match matches name against pattern, where * matches any characters.
+func match(pattern string, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
+
+type writerLogger struct {
+	name string
+	lock *sync.Mutex
+	w    io.Writer
+}
+
+// NewWriter returns a logger writing every message to w, prefixed with name.
+func NewWriter(name string, w io.Writer) Logger {
+	return &writerLogger{name: name, lock: &sync.Mutex{}, w: w}
+}
+
+func (l *writerLogger) log(level string, msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(time.Now().Format("15:04:05.000"))
+	b.WriteByte(' ')
+	b.WriteString(l.name)
+	b.WriteByte(' ')
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " !BADKEY=%v", args[i])
+		}
+	}
+	b.WriteByte('\n')
+
+	defer l.lock.Unlock()
+	l.lock.Lock()
+	io.WriteString(l.w, b.String())
+}
+
+func (l *writerLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args) }
+func (l *writerLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args) }
+func (l *writerLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args) }
+func (l *writerLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args) }
+
+type withLogger struct {
+	Logger
+	args []interface{}
+}
+
+// With returns a logger adding args to every message of l.
+func With(l Logger, args ...interface{}) Logger {
+	if l == Nop {
+		return l
+	}
+	if w, ok := l.(*withLogger); ok {
+		return &withLogger{Logger: w.Logger, args: append(append([]interface{}{}, w.args...), args...)}
+	}
+	return &withLogger{Logger: l, args: args}
+}
+
+func (l *withLogger) Debug(msg string, args ...interface{}) {
+	l.Logger.Debug(msg, append(append([]interface{}{}, l.args...), args...)...)
+}
+
+func (l *withLogger) Info(msg string, args ...interface{}) {
+	l.Logger.Info(msg, append(append([]interface{}{}, l.args...), args...)...)
+}
+
+func (l *withLogger) Warn(msg string, args ...interface{}) {
+	l.Logger.Warn(msg, append(append([]interface{}{}, l.args...), args...)...)
+}
+
+func (l *withLogger) Error(msg string, args ...interface{}) {
+	l.Logger.Error(msg, append(append([]interface{}{}, l.args...), args...)...)
+}
@@ -0,0 +1,46 @@
+package debug
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEnabled(t *testing.T) {
+	cases := []struct {
+		patterns string
+		name     string
+		enabled  bool
+	}{
+		{"", "goseio:engine", false},
+		{"goseio:*", "goseio:engine", true},
+		{"goseio:*", "goseio", false},
+		{"*", "goseio:socket", true},
+		{"goseio:engine", "goseio:engine", true},
+		{"goseio:engine", "goseio:socket", false},
+		{"foo, goseio:socket", "goseio:socket", true},
+		{"goseio:*,-goseio:engine", "goseio:engine", false},
+		{"goseio:*,-goseio:engine", "goseio:socket", true},
+		{"go*:*ne", "goseio:engine", true},
+		{"go*:*ne", "goseio:socket", false},
+	}
+	for _, c := range cases {
+		if enabled := Enabled(c.patterns, c.name); enabled != c.enabled {
+			t.Errorf("DEBUG=%q %s: expected %v, got %v", c.patterns, c.name, c.enabled, enabled)
+		}
+	}
+}
+
+func TestWith(t *testing.T) {
+	var buf bytes.Buffer
+	logger := With(With(NewWriter("goseio:engine", &buf), "sid", "abc"), "transport", "polling")
+	logger.Warn("closed", "reason", "ping timeout")
+
+	line := buf.String()
+	if !strings.HasSuffix(line, " goseio:engine WARN closed sid=abc transport=polling reason=ping timeout\n") {
+		t.Fatalf("unexpected line %q", line)
+	}
+	if With(Nop, "sid", "abc") != Nop {
+		t.Fatal("With wrapped Nop")
+	}
+}
@@ -1,10 +1,75 @@
 package eio
 
-import "sync"
+import (
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"github.com/adrianmxb/goseio/pkg/eio/transport"
+	"sync"
+)
 
+// Broadcaster is a set of sockets that receive the same messages. A message gets encoded once per kind of
+// transport instead of once per socket. Closed sockets are removed.
 type Broadcaster struct {
-	Publisher chan<- interface{}
+	lock    sync.RWMutex
+	sockets map[string]*Socket
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{sockets: make(map[string]*Socket)}
+}
+
+// Add adds socket until it is removed or closed.
+func (b *Broadcaster) Add(socket *Socket) {
+	b.lock.Lock()
+	if _, ok := b.sockets[socket.Id]; ok {
+		b.lock.Unlock()
+		return
+	}
+	b.sockets[socket.Id] = socket
+	b.lock.Unlock()
+
+	socket.OnClose(func(reason string, err error) {
+		b.Remove(socket)
+	})
+}
+
+func (b *Broadcaster) Remove(socket *Socket) {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	if b.sockets[socket.Id] == socket {
+		delete(b.sockets, socket.Id)
+	}
+}
+
+// Len returns the number of sockets.
+func (b *Broadcaster) Len() int {
+	defer b.lock.RUnlock()
+	b.lock.RLock()
+	return len(b.sockets)
+}
+
+// Broadcast sends a message to every socket, see Broadcast.
+func (b *Broadcaster) Broadcast(data []byte, isBinary bool, opts SendOptions) {
+	b.lock.RLock()
+	sockets := make([]*Socket, 0, len(b.sockets))
+	for _, socket := range b.sockets {
+		sockets = append(sockets, socket)
+	}
+	b.lock.RUnlock()
+
+	Broadcast(sockets, data, isBinary, opts)
+}
 
-	lock    sync.Mutex
-	readers map[chan<- interface{}]struct{}
+// Broadcast sends a message to sockets like Socket.Send, encoding it once per kind of transport.
+// The callback of opts gets called once per socket. data must not be changed afterwards.
+func Broadcast(sockets []*Socket, data []byte, isBinary bool, opts SendOptions) {
+	pack := packet.Packet{
+		PacketType: packet.Message,
+		IsBinary:   isBinary,
+		Compress:   opts.Compress,
+		Volatile:   opts.Volatile,
+	}
+	prepared := transport.NewPrepared(pack, data)
+	for _, socket := range sockets {
+		socket.send(pack, data, prepared, opts.Callback)
+	}
 }
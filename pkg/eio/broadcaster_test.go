@@ -0,0 +1,61 @@
+package eio
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBroadcaster(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBroadcaster()
+	connected := make(chan *Socket, 2)
+	s.OnConnection(func(socket *Socket) {
+		b.Add(socket)
+		connected <- socket
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+	var clients []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		clients = append(clients, ws)
+		<-connected
+	}
+
+	b.Broadcast([]byte("hello"), false, SendOptions{})
+	for _, ws := range clients {
+		for {
+			ws.SetReadDeadline(time.Now().Add(time.Second))
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg) == "4hello" {
+				break
+			}
+		}
+	}
+
+	// closed sockets are removed.
+	clients[0].WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	deadline := time.Now().Add(time.Second)
+	for b.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 1 socket, got %d", b.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,53 @@
+package eio
+
+import (
+	"context"
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"github.com/adrianmxb/goseio/pkg/eio/transport"
+	"github.com/adrianmxb/goseio/pkg/stream"
+	"net"
+)
+
+// NewConn returns a net.Conn tunneling a byte stream through the binary messages of socket, e.g. to run
+// another protocol where only engine.io traffic passes. Every Write is sent as one or more binary messages,
+// Read returns the binary messages of the client. Text messages are ignored.
+//
+// Received messages still reach the message handler of the server. Closing the conn closes the socket,
+// once the socket is closed Read returns io.EOF. Once stream.ReadBuffer messages are waiting to be read, the
+// socket stops reading the client until the conn is read, like a full message queue. If that takes longer than
+// PingInterval and PingTimeout, the socket gets closed with a ping timeout and Read fails with
+// stream.ErrReadBufferFull.
+func NewConn(socket *Socket) net.Conn {
+	conn := stream.New(stream.Options{
+		Send: func(data []byte, done func(err error)) error {
+			return socket.Send(data, true, SendOptions{Callback: done})
+		},
+		Close: func() error {
+			socket.Close()
+			return nil
+		},
+		Local:  stream.Addr{Net: "eio", Address: socket.Id},
+		Remote: stream.Addr{Net: "eio", Address: socket.remoteAddr},
+	})
+
+	socket.OnPacket(func(pack packet.Packet, data []byte) {
+		if pack.PacketType != packet.Message || !pack.IsBinary {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(socket.Context(), socket.server.PingInterval+socket.server.PingTimeout)
+		defer cancel()
+		if err := conn.Push(ctx, data); err == context.DeadlineExceeded {
+			// the tunnel is broken once a message is lost.
+			conn.CloseReadError(stream.ErrReadBufferFull)
+			socket.transportLock.RLock()
+			tsp := socket.Transport
+			socket.transportLock.RUnlock()
+			socket.stalled(tsp, transport.ErrPingTimeout)
+		}
+	})
+	socket.OnClose(func(reason string, err error) {
+		conn.CloseRead()
+	})
+	return conn
+}
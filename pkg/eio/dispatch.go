@@ -0,0 +1,164 @@
+package eio
+
+import (
+	"github.com/adrianmxb/goseio/pkg/eio/transport"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// DispatchMode decides which goroutine calls the MsgHandler of a server.
+type DispatchMode int
+
+const (
+	// DispatchInline calls MsgHandler in the goroutine reading the transport, a slow handler delays pings and
+	// upgrades of the socket.
+	DispatchInline DispatchMode = iota
+	// DispatchSerial calls MsgHandler in a goroutine per socket, one message after another.
+	DispatchSerial
+	// DispatchPool calls MsgHandler in at most Server.Workers goroutines shared by all sockets. The messages of a
+	// socket are still handled one after another.
+	DispatchPool
+)
+
+const defaultDispatchQueue = 64
+
+// dispatcher queues the messages of a socket for its MsgHandler.
+type dispatcher struct {
+	queue chan Message
+	// running is 1 while a goroutine drains the queue.
+	running int32
+}
+
+// dispatch queues a message received by tsp, blocking while Server.DispatchQueue messages of the socket are
+// waiting, see enqueue.
+func (s *Socket) dispatch(tsp transport.ITransport, data []byte, isBinary bool) {
+	// messages received by an upgrading transport after the close are dropped.
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+
+	if err := s.enqueue(tsp, s.dispatcher.queue, Message{Data: data, IsBinary: isBinary}); err != nil {
+		s.stalled(tsp, err)
+		return
+	}
+
+	if atomic.CompareAndSwapInt32(&s.dispatcher.running, 0, 1) {
+		s.server.wg.Add(1)
+		if s.server.Dispatch == DispatchPool {
+			s.server.workers.start(s.server.Workers)
+			s.server.workers.push(s)
+		} else {
+			go s.drain()
+		}
+	}
+}
+
+// drain handles the queued messages until the queue is empty.
+func (s *Socket) drain() {
+	for {
+		select {
+		case msg := <-s.dispatcher.queue:
+			s.server.MsgHandler(s, msg.Data, msg.IsBinary)
+			continue
+		default:
+		}
+
+		if !s.release() {
+			return
+		}
+	}
+}
+
+// release marks the queue as drained, unless a message was queued after it was found empty. It reports whether
+// the caller has to keep handling the queue.
+func (s *Socket) release() bool {
+	atomic.StoreInt32(&s.dispatcher.running, 0)
+	// a message queued after the queue was found empty didn't schedule the socket again.
+	if len(s.dispatcher.queue) == 0 || !atomic.CompareAndSwapInt32(&s.dispatcher.running, 0, 1) {
+		s.server.wg.Done()
+		return false
+	}
+	return true
+}
+
+// workerPool runs the goroutines of DispatchPool. They take turns on the sockets with queued messages, one
+// message at a time, so a busy socket doesn't starve the others.
+type workerPool struct {
+	once  sync.Once
+	lock  sync.Mutex
+	cond  *sync.Cond
+	ready []*Socket
+	// closed stops the workers once the server shut down.
+	closed bool
+}
+
+func newWorkerPool() *workerPool {
+	p := &workerPool{}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+// start starts the workers once, runtime.NumCPU() if workers isn't set.
+func (p *workerPool) start(workers int) {
+	p.once.Do(func() {
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+		for i := 0; i < workers; i++ {
+			go p.run()
+		}
+	})
+}
+
+// push adds a socket with queued messages, every socket is added at most once.
+func (p *workerPool) push(s *Socket) {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+	p.ready = append(p.ready, s)
+	p.cond.Signal()
+}
+
+func (p *workerPool) pop() (*Socket, bool) {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+	for len(p.ready) == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.closed {
+		return nil, false
+	}
+	s := p.ready[0]
+	p.ready[0] = nil
+	p.ready = p.ready[1:]
+	return s, true
+}
+
+func (p *workerPool) run() {
+	for {
+		s, ok := p.pop()
+		if !ok {
+			return
+		}
+
+		select {
+		case msg := <-s.dispatcher.queue:
+			s.server.MsgHandler(s, msg.Data, msg.IsBinary)
+		default:
+		}
+		// the socket queues up behind the others.
+		if len(s.dispatcher.queue) > 0 || s.release() {
+			p.push(s)
+		}
+	}
+}
+
+func (p *workerPool) close() {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+	p.closed = true
+	p.ready = nil
+	p.cond.Broadcast()
+}
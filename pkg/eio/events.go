@@ -0,0 +1,196 @@
+package eio
+
+import (
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"github.com/adrianmxb/goseio/pkg/eio/transport"
+	"sync"
+)
+
+// Close reasons passed to the close listeners of a socket.
+const (
+	// CloseReasonTransportClose is used if the client closed the connection.
+	CloseReasonTransportClose = "transport close"
+	// CloseReasonTransportError is used if the connection failed, the error gets passed along.
+	CloseReasonTransportError = "transport error"
+	// CloseReasonPingTimeout is used if the client didn't send anything within PingInterval and PingTimeout.
+	CloseReasonPingTimeout = "ping timeout"
+	// CloseReasonForcedClose is used if the socket was closed by the server.
+	CloseReasonForcedClose = "forced close"
+)
+
+type PacketListener func(pack packet.Packet, data []byte)
+type FlushListener func(frames []transport.Frame)
+type UpgradeListener func(transport string)
+type CloseListener func(reason string, err error)
+type ErrorListener func(err error)
+
+// listeners are the per socket event listeners, they get called synchronously in the goroutine of the event.
+type listeners struct {
+	lock         sync.RWMutex
+	packet       []PacketListener
+	packetCreate []PacketListener
+	heartbeat    []func()
+	flush        []FlushListener
+	drain        []func()
+	upgrading    []UpgradeListener
+	upgrade      []UpgradeListener
+	close        []CloseListener
+	error        []ErrorListener
+}
+
+// OnPacket adds a listener for every packet received from the client, including pings.
+func (s *Socket) OnPacket(fn PacketListener) {
+	defer s.listeners.lock.Unlock()
+	s.listeners.lock.Lock()
+	s.listeners.packet = append(s.listeners.packet, fn)
+}
+
+// OnPacketCreate adds a listener for every packet queued for the client.
+func (s *Socket) OnPacketCreate(fn PacketListener) {
+	defer s.listeners.lock.Unlock()
+	s.listeners.lock.Lock()
+	s.listeners.packetCreate = append(s.listeners.packetCreate, fn)
+}
+
+// OnHeartbeat adds a listener for every ping of the client.
+func (s *Socket) OnHeartbeat(fn func()) {
+	defer s.listeners.lock.Unlock()
+	s.listeners.lock.Lock()
+	s.listeners.heartbeat = append(s.listeners.heartbeat, fn)
+}
+
+// OnFlush adds a listener for the frames that are about to be written to the client.
+func (s *Socket) OnFlush(fn FlushListener) {
+	defer s.listeners.lock.Unlock()
+	s.listeners.lock.Lock()
+	s.listeners.flush = append(s.listeners.flush, fn)
+}
+
+// OnDrain adds a listener that gets called every time the send queue was flushed, like Server.OnDrain.
+func (s *Socket) OnDrain(fn func()) {
+	defer s.listeners.lock.Unlock()
+	s.listeners.lock.Lock()
+	s.listeners.drain = append(s.listeners.drain, fn)
+}
+
+// OnUpgrading adds a listener that gets called once the client probed the new transport.
+func (s *Socket) OnUpgrading(fn UpgradeListener) {
+	defer s.listeners.lock.Unlock()
+	s.listeners.lock.Lock()
+	s.listeners.upgrading = append(s.listeners.upgrading, fn)
+}
+
+// OnUpgrade adds a listener that gets called once the client switched to the new transport.
+func (s *Socket) OnUpgrade(fn UpgradeListener) {
+	defer s.listeners.lock.Unlock()
+	s.listeners.lock.Lock()
+	s.listeners.upgrade = append(s.listeners.upgrade, fn)
+}
+
+// OnClose adds a listener that gets called with one of the CloseReason constants once the socket is closed.
+// err is only set for CloseReasonTransportError. If the socket is closed already, fn gets called right away.
+func (s *Socket) OnClose(fn CloseListener) {
+	s.listeners.lock.Lock()
+	s.stateLock.Lock()
+	closed := s.readyState == ReadyStateClosed
+	reason, err := s.closeReason, s.closeErr
+	s.stateLock.Unlock()
+	if !closed {
+		s.listeners.close = append(s.listeners.close, fn)
+	}
+	s.listeners.lock.Unlock()
+
+	if closed {
+		fn(reason, err)
+	}
+}
+
+// OnError adds a listener for errors of the transports, the socket gets closed afterwards.
+func (s *Socket) OnError(fn ErrorListener) {
+	defer s.listeners.lock.Unlock()
+	s.listeners.lock.Lock()
+	s.listeners.error = append(s.listeners.error, fn)
+}
+
+func (s *Socket) emitPacket(pack packet.Packet, data []byte) {
+	s.listeners.lock.RLock()
+	fns := s.listeners.packet
+	s.listeners.lock.RUnlock()
+	for _, fn := range fns {
+		fn(pack, data)
+	}
+}
+
+func (s *Socket) emitPacketCreate(pack packet.Packet, data []byte) {
+	s.listeners.lock.RLock()
+	fns := s.listeners.packetCreate
+	s.listeners.lock.RUnlock()
+	for _, fn := range fns {
+		fn(pack, data)
+	}
+}
+
+func (s *Socket) emitHeartbeat() {
+	s.listeners.lock.RLock()
+	fns := s.listeners.heartbeat
+	s.listeners.lock.RUnlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+func (s *Socket) emitFlush(frames []transport.Frame) {
+	s.listeners.lock.RLock()
+	fns := s.listeners.flush
+	s.listeners.lock.RUnlock()
+	for _, fn := range fns {
+		fn(frames)
+	}
+}
+
+func (s *Socket) emitDrain() {
+	s.listeners.lock.RLock()
+	fns := s.listeners.drain
+	s.listeners.lock.RUnlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+func (s *Socket) emitUpgrading(transport string) {
+	s.listeners.lock.RLock()
+	fns := s.listeners.upgrading
+	s.listeners.lock.RUnlock()
+	for _, fn := range fns {
+		fn(transport)
+	}
+}
+
+func (s *Socket) emitUpgrade(transport string) {
+	s.listeners.lock.RLock()
+	fns := s.listeners.upgrade
+	s.listeners.lock.RUnlock()
+	for _, fn := range fns {
+		fn(transport)
+	}
+}
+
+// emitClose calls the close listeners, OnClose doesn't add listeners once the socket is closed.
+func (s *Socket) emitClose(reason string, err error) {
+	s.listeners.lock.Lock()
+	fns := s.listeners.close
+	s.listeners.close = nil
+	s.listeners.lock.Unlock()
+	for _, fn := range fns {
+		fn(reason, err)
+	}
+}
+
+func (s *Socket) emitError(err error) {
+	s.listeners.lock.RLock()
+	fns := s.listeners.error
+	s.listeners.lock.RUnlock()
+	for _, fn := range fns {
+		fn(err)
+	}
+}
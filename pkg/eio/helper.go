@@ -3,8 +3,13 @@ package eio
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"net/http"
 )
 
+// IDGeneratorFunc returns the session id for the handshake request r. The id is sent as query parameter by
+// the client, so it should only contain URL safe characters.
+type IDGeneratorFunc func(r *http.Request) (string, error)
+
 func GenerateID() (string, error) {
 	b := make([]byte, 24)
 	if _, err := rand.Read(b); err != nil {
@@ -12,3 +17,12 @@ func GenerateID() (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(b), nil
 }
+
+// PrefixedID returns a generator for random ids starting with prefix, e.g. the name of the node so a load
+// balancer can route the requests of a client to it.
+func PrefixedID(prefix string) IDGeneratorFunc {
+	return func(r *http.Request) (string, error) {
+		id, err := GenerateID()
+		return prefix + id, err
+	}
+}
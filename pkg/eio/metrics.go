@@ -0,0 +1,31 @@
+package eio
+
+import "time"
+
+// Metrics receives the events of a server, e.g. metrics.Prometheus. The methods get called concurrently.
+type Metrics interface {
+	// Handshake is called for every new client.
+	Handshake(transport string)
+	// Upgrade is called once a client switched to the transport to.
+	Upgrade(from string, to string)
+	// UpgradeFailed is called if an upgrade to the transport was aborted.
+	UpgradeFailed(transport string)
+	// Closed is called once a client is gone.
+	Closed(transport string)
+	// Received is called for every packet received from a client.
+	Received(transport string, bytes int)
+	// Sent is called for every message queued for a client.
+	Sent(transport string, bytes int)
+	// PollRequest is called once a polling request was answered.
+	PollRequest(method string, duration time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Handshake(transport string)                        {}
+func (nopMetrics) Upgrade(from string, to string)                    {}
+func (nopMetrics) UpgradeFailed(transport string)                    {}
+func (nopMetrics) Closed(transport string)                           {}
+func (nopMetrics) Received(transport string, bytes int)              {}
+func (nopMetrics) Sent(transport string, bytes int)                  {}
+func (nopMetrics) PollRequest(method string, duration time.Duration) {}
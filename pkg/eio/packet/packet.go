@@ -17,6 +17,10 @@ const (
 type Packet struct {
 	PacketType PacketType
 	IsBinary   bool
+	// Compress allows the transport to compress the packet, e.g. with permessage-deflate.
+	Compress bool
+	// Volatile packets may be dropped if the send queue is full, see transport.OverflowDropVolatile.
+	Volatile bool
 }
 
 var packetError = errors.New("invalid packet")
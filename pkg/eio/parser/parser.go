@@ -60,12 +60,11 @@ func AnalyzeReader(r io.Reader) (*packet.Packet, []byte, error) {
 	bytes, _ := ioutil.ReadAll(r)
 
 	p, err := packet.GetPacketFromByte(b[0], false)
-
-	if p.PacketType > packet.Noop {
-		fmt.Println("invalid")
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return p, bytes, err
+	return p, bytes, nil
 }
 
 var binaryByte = []byte{1}
@@ -74,7 +73,7 @@ var endByte = []byte{255}
 func EncodePayloadLength(w io.Writer, packet packet.Packet, data []byte, supportsBinary bool) (int, error) {
 	count := len(data)
 	//adjust packetRuneCount for utf-16 size we got from engine.io
-	if !packet.IsBinary || packet.IsBinary && !supportsBinary {
+	if !packet.IsBinary {
 		count = utf8.RuneCount(data)
 		uncheckedRunes := data
 		for len(uncheckedRunes) > 0 {
@@ -106,9 +105,8 @@ func EncodePayloadLength(w io.Writer, packet packet.Packet, data []byte, support
 		return len(binaryByte) + len(encodedLength) + len(endByte) + count, nil
 	} else {
 		if packet.IsBinary {
-			count = base64.StdEncoding.EncodedLen(count)
-			// 'b' (1 character -> count++) character to signal that the packet uses base64 encoding
-			count++
+			// 'b' and the packet type are followed by the base64 encoded data.
+			count = 2 + base64.StdEncoding.EncodedLen(len(data))
 		}
 
 		var payloadLen []byte
@@ -128,57 +126,41 @@ func DecodePayload(r io.Reader, length int) ([]*bytes.Reader, error) {
 	}
 
 	var readers []*bytes.Reader
-	//packet length, in UTF-16 (wow, thank you javascript)
-	packetLength := 0
-	read := 0
 	for len(payload) != 0 {
-		if payload[read] != ':' {
+		//packet length, in UTF-16 (wow, thank you javascript)
+		packetLength := 0
+		read := 0
+		for read < len(payload) && payload[read] != ':' {
 			if payload[read] > '9' || payload[read] < '0' {
 				return nil, fmt.Errorf("invalid payload")
 			}
 			packetLength = packetLength*10 + int(payload[read]-'0')
 			read++
-			continue
 		}
-		//found packet!
-		packetStart := read + 1
-		packetSlice := payload[packetStart : packetStart+packetLength]
-
-		//got utf-8, find the "real" packet length.
-		packetRuneCount := packetLength
-
-		//adjust packetRuneCount for utf-16 size we got from engine.io
-		uncheckedRunes := packetSlice
-		for len(uncheckedRunes) > 0 {
-			lastRune, size := utf8.DecodeLastRune(uncheckedRunes)
-			if lastRune == utf8.RuneError {
-				fmt.Errorf("invalid payload")
-				return nil, err
-			}
-			if r1, r2 := utf16.EncodeRune(lastRune); r1 != utf8.RuneError || r2 != utf8.RuneError {
-				packetRuneCount--
-			}
-			uncheckedRunes = uncheckedRunes[:len(uncheckedRunes)-size]
+		if read == len(payload) {
+			return nil, fmt.Errorf("invalid payload")
 		}
 
-		for utf8.RuneCount(packetSlice) < packetRuneCount || !utf8.Valid(packetSlice) {
-			packetLength++
-			if packetStart+packetLength > len(payload) {
+		//found packet! walk the utf-8 runes until we got packetLength utf-16 code units.
+		packetStart := read + 1
+		packetEnd := packetStart
+		for units := 0; units < packetLength; {
+			if packetEnd >= len(payload) {
+				return nil, fmt.Errorf("invalid payload")
+			}
+			r, size := utf8.DecodeRune(payload[packetEnd:])
+			if r == utf8.RuneError && size <= 1 {
 				return nil, fmt.Errorf("invalid payload")
 			}
-			packetSlice = payload[packetStart : packetStart+packetLength]
-
-			//fixup for utf-16
-			lastRune, _ := utf8.DecodeLastRune(packetSlice)
-			if lastRune != utf8.RuneError {
-				if r1, r2 := utf16.EncodeRune(lastRune); r1 != utf8.RuneError || r2 != utf8.RuneError {
-					packetRuneCount--
-				}
+			if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError || r2 != utf8.RuneError {
+				units++
 			}
+			units++
+			packetEnd += size
 		}
 
-		readers = append(readers, bytes.NewReader(packetSlice))
-		payload = payload[packetStart+packetLength:]
+		readers = append(readers, bytes.NewReader(payload[packetStart:packetEnd]))
+		payload = payload[packetEnd:]
 	}
 	return readers, nil
 }
@@ -2,8 +2,10 @@ package parser
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -52,3 +54,29 @@ func BenchmarkMethod3(b *testing.B) {
 		_ = []byte(fmt.Sprintf("%d:", n))
 	}
 }
+
+func TestDecodePayload(t *testing.T) {
+	// lengths are counted in utf-16 code units: é is one unit, 😀 two.
+	payload := "2:4é3:4😀5:4abcd"
+	readers, err := DecodePayload(strings.NewReader(payload), len(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"4é", "4😀", "4abcd"}
+	if len(readers) != len(expected) {
+		t.Fatalf("expected %d packets, got %d", len(expected), len(readers))
+	}
+	for i, reader := range readers {
+		b, _ := ioutil.ReadAll(reader)
+		if string(b) != expected[i] {
+			t.Fatalf("expected %q, got %q", expected[i], b)
+		}
+	}
+
+	for _, invalid := range []string{"3:4é", "x:4", "2", "2:4\xff"} {
+		if _, err := DecodePayload(strings.NewReader(invalid), len(invalid)); err == nil {
+			t.Fatalf("expected error for %q", invalid)
+		}
+	}
+}
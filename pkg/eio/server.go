@@ -2,7 +2,11 @@ package eio
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"github.com/adrianmxb/goseio/pkg/debug"
 	"github.com/adrianmxb/goseio/pkg/eio/transport"
+	"github.com/adrianmxb/goseio/pkg/tracing"
 	"github.com/gorilla/websocket"
 	jsoniter "github.com/json-iterator/go"
 	"net/http"
@@ -11,7 +15,17 @@ import (
 	"time"
 )
 
-var json = jsoniter.ConfigCompatibleWithStandardLibrary
+// JSON is the json configuration of eio, sio uses it as well.
+var JSON = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var json = JSON
+
+var (
+	errAlreadyUpgraded = errors.New("already upgraded")
+	errShuttingDown    = errors.New("server shutting down")
+	errEmptyID         = errors.New("generated session id is empty")
+	errDuplicateID     = errors.New("generated session id is in use")
+)
 
 type Config struct {
 	PingTimeout    int
@@ -22,6 +36,13 @@ type Config struct {
 
 type ConnectHandlerFunc func(socket *Socket)
 type MessageHandlerFunc func(socket *Socket, data []byte, isBinary bool)
+type DrainHandlerFunc func(socket *Socket)
+
+// HeadersHandlerFunc may change the headers of a response before they are written, e.g. to set a cookie.
+type HeadersHandlerFunc func(headers http.Header, r *http.Request)
+
+// ConnectionErrorHandlerFunc gets called for every request that got rejected.
+type ConnectionErrorHandlerFunc func(err *ConnectionError)
 
 const (
 	UnknownTransport = iota
@@ -53,13 +74,76 @@ type RequestError struct {
 	Message string `json:"message"`
 }
 
+var errorMessages = map[int]string{
+	UnknownTransport:   "Transport unknown",
+	UnknownSid:         "Session ID unknown",
+	BadHandshakeMethod: "Bad handshake method",
+	BadRequest:         "Bad request",
+	Forbidden:          "Forbidden",
+}
+
+// CookieOptions configure the cookie set on the handshake, its value is the session id.
+// It allows load balancers to route the requests of a client to the same node.
+// engine.io uses &CookieOptions{HttpOnly: true, SameSite: http.SameSiteLaxMode} by default.
+type CookieOptions struct {
+	// Name defaults to "io".
+	Name string
+	// Path defaults to "/".
+	Path     string
+	HttpOnly bool
+	SameSite http.SameSite
+	Secure   bool
+	// MaxAge in seconds, 0 creates a session cookie.
+	MaxAge int
+}
+
+// ConnectionError describes a rejected request.
+type ConnectionError struct {
+	// Code is one of UnknownTransport, UnknownSid, BadHandshakeMethod, BadRequest and Forbidden.
+	Code    int
+	Message string
+	Request *http.Request
+}
+
+func (e *ConnectionError) Error() string {
+	return e.Message
+}
+
 type Server struct {
 	clientsMutex sync.RWMutex
 	clients      map[string]*Socket
-	errors       map[int][]byte
+	// reserved are the ids of the handshakes in progress.
+	reserved map[string]struct{}
+	closing  bool
+	// wg tracks the goroutines handling the transports of the clients.
+	wg     sync.WaitGroup
+	errors map[int][]byte
 
 	MsgHandler     MessageHandlerFunc
 	ConnectHandler ConnectHandlerFunc
+	DrainHandler   DrainHandlerFunc
+	// InitialHeadersHandler gets called for the response of the handshake, before HeadersHandler.
+	InitialHeadersHandler HeadersHandlerFunc
+	// HeadersHandler gets called for the response of every polling request and websocket upgrade.
+	HeadersHandler         HeadersHandlerFunc
+	ConnectionErrorHandler ConnectionErrorHandlerFunc
+
+	// Logger defaults to debug.New("goseio:engine").
+	Logger debug.Logger
+	// Metrics defaults to discarding everything.
+	Metrics Metrics
+	// IDGenerator creates the session ids of new clients, they are rejected if the id is in use already.
+	// Defaults to GenerateID.
+	IDGenerator IDGeneratorFunc
+	// Cookie is set on the handshake response if not nil.
+	Cookie *CookieOptions
+	// Transports are the transports clients may use, defaults to "polling" and "websocket".
+	Transports []string
+	// AllowUpgrades allows clients to upgrade from polling to websocket, defaults to true.
+	AllowUpgrades bool
+	// Tracer records the spans eio.handshake and eio.upgrade, defaults to tracing.Nop.
+	// The trace context of a client is taken from the traceparent header or query parameter of the handshake.
+	Tracer tracing.Tracer
 
 	Path         string
 	PingInterval time.Duration
@@ -68,13 +152,36 @@ type Server struct {
 	// for sio 2.0 supports
 	initialPacket []byte
 
+	// send queue of every transport, see transport.TransportOptions.
+	QueueSize    int
+	Overflow     transport.OverflowPolicy
+	QueueTimeout time.Duration
+
+	// MessageBuffer enables Socket.Messages and Socket.Next, they receive the messages of the clients instead of
+	// MsgHandler. Once MessageBuffer messages of a socket are waiting, it stops reading until one is consumed.
+	MessageBuffer int
+
+	// Dispatch decides which goroutine calls MsgHandler, defaults to DispatchInline.
+	Dispatch DispatchMode
+	// DispatchQueue is the number of messages of a socket queued for MsgHandler by DispatchSerial and DispatchPool.
+	// Once it is full, the socket stops reading until a message was handled. Defaults to 64.
+	DispatchQueue int
+	// Workers is the number of goroutines of DispatchPool, defaults to runtime.NumCPU(). It is read once a message
+	// got dispatched.
+	Workers int
+	workers *workerPool
+
 	//poll
-	MaxHttpBufferSize uint64
-	HttpCompression   bool
+	// MaxHttpBufferSize limits the body of a polling data request, defaults to
+	// transport.DefaultMaxHttpBufferSize.
+	MaxHttpBufferSize      uint64
+	HttpCompression        bool
+	HttpCompressionOptions transport.HttpCompressionOptions
 
 	//ws
-	ws                websocket.Upgrader
-	PerMessageDeflate bool
+	ws                       websocket.Upgrader
+	PerMessageDeflate        bool
+	PerMessageDeflateOptions transport.PerMessageDeflateOptions
 }
 
 func NewServer(path string, packet bytes.Buffer) (*Server, error) {
@@ -82,11 +189,26 @@ func NewServer(path string, packet bytes.Buffer) (*Server, error) {
 	pt := 20000
 	deflate := false
 	s := &Server{
-		clients: make(map[string]*Socket),
-		errors:  make(map[int][]byte),
+		clients:  make(map[string]*Socket),
+		reserved: make(map[string]struct{}),
+		errors:   make(map[int][]byte),
 
 		MsgHandler:     func(socket *Socket, data []byte, isBinary bool) {},
 		ConnectHandler: func(socket *Socket) {},
+		DrainHandler:   func(socket *Socket) {},
+		Logger:         debug.New("goseio:engine"),
+		Metrics:        nopMetrics{},
+		Tracer:         tracing.Nop,
+
+		InitialHeadersHandler:  func(headers http.Header, r *http.Request) {},
+		HeadersHandler:         func(headers http.Header, r *http.Request) {},
+		ConnectionErrorHandler: func(err *ConnectionError) {},
+		IDGenerator: func(r *http.Request) (string, error) {
+			return GenerateID()
+		},
+
+		Transports:    []string{"polling", "websocket"},
+		AllowUpgrades: true,
 
 		Path:              path + "/",
 		PerMessageDeflate: deflate,
@@ -96,49 +218,29 @@ func NewServer(path string, packet bytes.Buffer) (*Server, error) {
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
-			EnableCompression: deflate,
 		},
 
 		initialPacket: packet.Bytes(),
 
 		PingInterval: time.Duration(pi) * time.Millisecond,
 		PingTimeout:  time.Duration(pt) * time.Millisecond,
-	}
-
-	if b, err := json.Marshal(&RequestError{Code: UnknownTransport, Message: "Transport unknown"}); err != nil {
-		return nil, err
-	} else {
-		s.errors[UnknownTransport] = b
-	}
-
-	if b, err := json.Marshal(&RequestError{Code: UnknownSid, Message: "Session ID unknown"}); err != nil {
-		return nil, err
-	} else {
-		s.errors[UnknownSid] = b
-	}
 
-	if b, err := json.Marshal(&RequestError{Code: BadHandshakeMethod, Message: "Bad handshake method"}); err != nil {
-		return nil, err
-	} else {
-		s.errors[BadHandshakeMethod] = b
+		DispatchQueue: defaultDispatchQueue,
+		workers:       newWorkerPool(),
 	}
 
-	if b, err := json.Marshal(&RequestError{Code: BadRequest, Message: "Bad request"}); err != nil {
-		return nil, err
-	} else {
-		s.errors[BadRequest] = b
-	}
-
-	if b, err := json.Marshal(&RequestError{Code: Forbidden, Message: "Forbidden"}); err != nil {
-		return nil, err
-	} else {
-		s.errors[Forbidden] = b
+	for code, message := range errorMessages {
+		b, err := json.Marshal(&RequestError{Code: code, Message: message})
+		if err != nil {
+			return nil, err
+		}
+		s.errors[code] = b
 	}
 	return s, nil
 }
 
-//if sync is set the message handler gets called in a synchronized manner so you don't have to
-//synchronize access to data.
+// OnMessage sets the handler of the messages of the clients. The messages of a socket are handled one after
+// another, in the goroutine chosen by Dispatch.
 func (s *Server) OnMessage(handlerFunc MessageHandlerFunc) {
 	s.MsgHandler = handlerFunc
 }
@@ -147,12 +249,38 @@ func (s *Server) OnConnection(handlerFunc ConnectHandlerFunc) {
 	s.ConnectHandler = handlerFunc
 }
 
+// OnDrain sets the handler called every time the send queue of a socket was flushed.
+func (s *Server) OnDrain(handlerFunc DrainHandlerFunc) {
+	s.DrainHandler = handlerFunc
+}
+
+func (s *Server) OnInitialHeaders(handlerFunc HeadersHandlerFunc) {
+	s.InitialHeadersHandler = handlerFunc
+}
+
+func (s *Server) OnHeaders(handlerFunc HeadersHandlerFunc) {
+	s.HeadersHandler = handlerFunc
+}
+
+func (s *Server) OnConnectionError(handlerFunc ConnectionErrorHandlerFunc) {
+	s.ConnectionErrorHandler = handlerFunc
+}
+
+// connectionError reports a rejected request.
+func (s *Server) connectionError(r *http.Request, errCode int) {
+	message, ok := errorMessages[errCode]
+	if !ok {
+		errCode, message = Forbidden, errorMessages[Forbidden]
+	}
+	s.ConnectionErrorHandler(&ConnectionError{Code: errCode, Message: message, Request: r})
+}
+
 func (s *Server) VerifyRequest(query url.Values, r *http.Request, upgrade bool) (bool, int) {
 	//eio := query.Get("eio")
 	transport := query.Get("transport")
 	sid := query.Get("sid")
 
-	if transport != "polling" && transport != "websocket" {
+	if (transport != "polling" && transport != "websocket") || !s.allowsTransport(transport) {
 		return false, UnknownTransport
 	}
 
@@ -160,30 +288,36 @@ func (s *Server) VerifyRequest(query url.Values, r *http.Request, upgrade bool)
 
 	if sid != "" {
 		s.clientsMutex.RLock()
-		_ /*client*/, ok := s.clients[sid]
+		client, ok := s.clients[sid]
 		s.clientsMutex.RUnlock()
 		if !ok {
-			s.clientsMutex.RUnlock()
 			return false, UnknownSid
-		} else {
-			/* TODO:
-			   if (!upgrade && this.clients[sid].Transport.name !== Transport) {
-			     debug('bad request: unexpected Transport without upgrade');
-			     return fn(Server.errors.BAD_REQUEST, false);
-			   }
-			*/
+		}
+		if upgrade && !s.AllowUpgrades {
+			return false, BadRequest
+		}
+		if !upgrade && client.TransportName() != transport {
+			return false, BadRequest
 		}
 	} else {
 		if r.Method != "GET" {
 			return false, BadHandshakeMethod
 		}
 
+		s.clientsMutex.RLock()
+		closing := s.closing
+		s.clientsMutex.RUnlock()
+		if closing {
+			return false, Forbidden
+		}
+
 		//TODO: add call to optional verification function provided by user. "return s.allowRequest(request)" -> returns ok,errorCode
 	}
 	return true, -1
 }
 
 func (s *Server) SendError(w http.ResponseWriter, r *http.Request, errCode int) {
+	s.connectionError(r, errCode)
 	w.Header().Set("Content-Type", "application/json")
 
 	err, ok := s.errors[errCode]
@@ -211,8 +345,14 @@ func (s *Server) HandleUpgrade(query url.Values, w http.ResponseWriter, r *http.
 		return
 	}
 
-	conn, err := s.ws.Upgrade(w, r, nil)
+	if !s.AllowUpgrades || !s.allowsTransport("websocket") {
+		s.SendError(w, r, BadRequest)
+		return
+	}
+
+	conn, err := s.upgrade(w, r, http.Header{})
 	if err != nil {
+		s.connectionError(r, BadRequest)
 		return
 	}
 
@@ -224,50 +364,173 @@ func (s *Server) HandleUpgrade(query url.Values, w http.ResponseWriter, r *http.
 		conn.Close()
 		return
 	}
+
+	_, span := s.Tracer.Start(client.TraceContext(), "eio.upgrade")
+	span.SetAttribute("eio.sid", id)
+	span.SetAttribute("eio.transport.from", client.TransportName())
+	span.SetAttribute("eio.transport.to", "websocket")
+
 	if client.upgradeState == UpgradeStateUpgrading ||
 		client.upgradeState == UpgradeStateUpgraded {
+		s.Metrics.UpgradeFailed("websocket")
+		span.RecordError(errAlreadyUpgraded)
+		span.End()
 		conn.Close()
 		return
 	}
 
-	transport := transport.NewWebsocket(
-		transport.WSOptions{
-			TransportOptions: transport.TransportOptions{
-				SupportsBinary: query.Get("b64") == "",
-			},
-		}, query.Get("sid"), conn)
+	transport := transport.NewWebsocket(s.wsOptions(id, query), id, conn)
+	s.Logger.Debug("upgrading", "sid", id, "transport", transport.GetName())
+	client.stateLock.Lock()
+	client.upgradeSpan = span
+	client.stateLock.Unlock()
 
+	s.wg.Add(1)
 	go client.HandleTransport(transport, true)
 }
 
+func (s *Server) allowsTransport(name string) bool {
+	for _, transport := range s.Transports {
+		if transport == name {
+			return true
+		}
+	}
+	return false
+}
+
+// upgrades returns the transports a client of transport may upgrade to.
+func (s *Server) upgrades(transport string) []string {
+	if !s.AllowUpgrades || transport != "polling" || !s.allowsTransport("websocket") {
+		return []string{}
+	}
+	return []string{"websocket"}
+}
+
+// upgrade upgrades the request to a websocket connection, negotiating permessage-deflate if enabled.
+// headers are sent with the response, after HeadersHandler was applied.
+func (s *Server) upgrade(w http.ResponseWriter, r *http.Request, headers http.Header) (*websocket.Conn, error) {
+	upgrader := s.ws
+	upgrader.EnableCompression = s.PerMessageDeflate
+	s.HeadersHandler(headers, r)
+	return upgrader.Upgrade(w, r, headers)
+}
+
+// initialHeaders sets the cookie and calls InitialHeadersHandler for the handshake of the client id.
+func (s *Server) initialHeaders(headers http.Header, id string, r *http.Request) {
+	if s.Cookie != nil {
+		cookie := &http.Cookie{
+			Name:     s.Cookie.Name,
+			Value:    id,
+			Path:     s.Cookie.Path,
+			HttpOnly: s.Cookie.HttpOnly,
+			SameSite: s.Cookie.SameSite,
+			Secure:   s.Cookie.Secure,
+			MaxAge:   s.Cookie.MaxAge,
+		}
+		if cookie.Name == "" {
+			cookie.Name = "io"
+		}
+		if cookie.Path == "" {
+			cookie.Path = "/"
+		}
+		headers.Add("Set-Cookie", cookie.String())
+	}
+	s.InitialHeadersHandler(headers, r)
+}
+
+func (s *Server) transportOptions(id string, name string, query url.Values) transport.TransportOptions {
+	return transport.TransportOptions{
+		Logger:         debug.With(s.Logger, "sid", id, "transport", name),
+		SupportsBinary: query.Get("b64") == "",
+		QueueSize:      s.QueueSize,
+		Overflow:       s.Overflow,
+		QueueTimeout:   s.QueueTimeout,
+	}
+}
+
+func (s *Server) wsOptions(id string, query url.Values) transport.WSOptions {
+	return transport.WSOptions{
+		TransportOptions:  s.transportOptions(id, "websocket", query),
+		PerMessageDeflate: s.PerMessageDeflateOptions,
+	}
+}
+
+// traceParent returns the trace context the client sent with the request.
+func traceParent(query url.Values, r *http.Request) string {
+	if traceParent := r.Header.Get("traceparent"); traceParent != "" {
+		return traceParent
+	}
+	return query.Get("traceparent")
+}
+
+// reserveID generates the id of a new client and reserves it until release is called.
+func (s *Server) reserveID(r *http.Request) (string, error) {
+	id, err := s.IDGenerator(r)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", errEmptyID
+	}
+
+	defer s.clientsMutex.Unlock()
+	s.clientsMutex.Lock()
+	if _, ok := s.clients[id]; ok {
+		return "", errDuplicateID
+	}
+	if _, ok := s.reserved[id]; ok {
+		return "", errDuplicateID
+	}
+	s.reserved[id] = struct{}{}
+	return id, nil
+}
+
+func (s *Server) releaseID(id string) {
+	defer s.clientsMutex.Unlock()
+	s.clientsMutex.Lock()
+	delete(s.reserved, id)
+}
+
 func (s *Server) Handshake(query url.Values, w http.ResponseWriter, r *http.Request) {
-	id, err := GenerateID()
+	id, err := s.reserveID(r)
 	if err != nil {
+		s.Logger.Warn("generating session id failed", "error", err)
+		s.SendError(w, r, BadRequest)
 		return
 	}
+	defer s.releaseID(id)
+
+	// the socket keeps the span as parent for its lifetime, it must not be canceled with the request.
+	ctx := tracing.Detach(tracing.Extract(r.Context(), traceParent(query, r)))
+	ctx, span := s.Tracer.Start(ctx, "eio.handshake")
+	defer span.End()
+	span.SetAttribute("eio.sid", id)
+	span.SetAttribute("eio.transport", query.Get("transport"))
+	r = r.WithContext(ctx)
 
 	var tsp transport.ITransport
 	switch query.Get("transport") {
 	case "websocket":
-		conn, err := s.ws.Upgrade(w, r, nil)
+		headers := http.Header{}
+		s.initialHeaders(headers, id, r)
+		conn, err := s.upgrade(w, r, headers)
 		if err != nil {
+			span.RecordError(err)
+			s.connectionError(r, BadRequest)
 			return
 		}
 
-		tsp = transport.NewWebsocket(
-			transport.WSOptions{
-				TransportOptions: transport.TransportOptions{
-					SupportsBinary: query.Get("b64") == "",
-				},
-			}, id, conn)
+		tsp = transport.NewWebsocket(s.wsOptions(id, query), id, conn)
 		break
 	case "polling":
 		pollingData := transport.PollingOptions{
-			TransportOptions: transport.TransportOptions{
-				SupportsBinary: query.Get("b64") == "",
+			TransportOptions:       s.transportOptions(id, "polling", query),
+			MaxHttpBufferSize:      s.MaxHttpBufferSize,
+			HttpCompression:        s.HttpCompression,
+			HttpCompressionOptions: s.HttpCompressionOptions,
+			Headers: func(headers http.Header, r *http.Request) {
+				s.HeadersHandler(headers, r)
 			},
-			MaxHttpBufferSize: s.MaxHttpBufferSize,
-			HttpCompression:   s.HttpCompression,
 		}
 		if query.Get("j") != "" {
 			pollingData.Type = transport.JSONP
@@ -281,15 +544,88 @@ func (s *Server) Handshake(query url.Values, w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	s.Logger.Debug("handshake", "sid", id, "transport", tsp.GetName())
+	s.Metrics.Handshake(tsp.GetName())
 	socket := NewSocket(id, s, tsp, r)
+	if tsp.GetName() == "polling" {
+		// the headers of a websocket handshake were set by the upgrade already.
+		s.initialHeaders(w.Header(), id, r)
+	}
 	tsp.HandleRequest(r, w)
 
 	s.clientsMutex.Lock()
-	s.clients[id] = socket
+	closing := s.closing
+	if !closing {
+		s.clients[id] = socket
+	}
 	s.clientsMutex.Unlock()
 
+	// the server started shutting down during the handshake.
+	if closing {
+		span.RecordError(errShuttingDown)
+		socket.Close()
+		return
+	}
+
 	s.ConnectHandler(socket)
-	// TODO: handle close!!! (delete from s.clients, ...)
+}
+
+// Clients returns the connected clients.
+func (s *Server) Clients() []*Socket {
+	defer s.clientsMutex.RUnlock()
+	s.clientsMutex.RLock()
+	clients := make([]*Socket, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+func (s *Server) remove(socket *Socket) {
+	defer s.clientsMutex.Unlock()
+	s.clientsMutex.Lock()
+	if s.clients[socket.Id] == socket {
+		delete(s.clients, socket.Id)
+	}
+}
+
+// Shutdown stops accepting new clients and closes the connected ones. It waits until their queued messages
+// were flushed and every goroutine of their transports exited. Once ctx expires, the remaining transports get closed right away
+// and the error of ctx is returned.
+//
+// When serving through an http.Server, call Shutdown before http.Server.Shutdown. The latter ignores hijacked
+// websocket connections and would wait for pending polling requests.
+func (s *Server) Shutdown(ctx context.Context) error {
+	defer s.workers.close()
+	s.clientsMutex.Lock()
+	s.closing = true
+	clients := make([]*Socket, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.clientsMutex.Unlock()
+
+	s.Logger.Info("shutting down", "clients", len(clients))
+	for _, client := range clients {
+		client.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Logger.Warn("shutdown timed out, closing the remaining clients", "error", ctx.Err())
+		for _, client := range clients {
+			client.forceClose()
+		}
+		return ctx.Err()
+	}
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -301,7 +637,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if ok, errCode := s.VerifyRequest(query, r, false); !ok {
+	upgrade := websocket.IsWebSocketUpgrade(r)
+	if ok, errCode := s.VerifyRequest(query, r, upgrade); !ok {
+		s.Logger.Debug("bad request", "code", errCode, "sid", query.Get("sid"), "transport", query.Get("transport"))
 		s.SendError(w, r, errCode)
 		return
 	}
@@ -311,10 +649,18 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.clientsMutex.RUnlock()
 
 	if ok {
-		if r.Header.Get("Connection") == "Upgrade" {
+		if upgrade {
 			s.HandleUpgrade(query, w, r)
 		} else {
-			client.Transport.HandleRequest(r, w)
+			client.transportLock.RLock()
+			tsp := client.Transport
+			client.transportLock.RUnlock()
+
+			start := time.Now()
+			tsp.HandleRequest(r, w)
+			if tsp.GetName() == "polling" {
+				s.Metrics.PollRequest(r.Method, time.Since(start))
+			}
 		}
 	} else {
 		s.Handshake(query, w, r)
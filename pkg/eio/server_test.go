@@ -0,0 +1,270 @@
+package eio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeadersAndConnectionError(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OnInitialHeaders(func(headers http.Header, r *http.Request) {
+		headers.Set("Set-Cookie", "io=handshake")
+	})
+	s.OnHeaders(func(headers http.Header, r *http.Request) {
+		headers.Set("X-Engine", "goseio")
+	})
+	errors := make(chan *ConnectionError, 1)
+	s.OnConnectionError(func(err *ConnectionError) {
+		errors <- err
+	})
+	sockets := make(chan *Socket, 1)
+	s.OnConnection(func(socket *Socket) {
+		sockets <- socket
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	url := srv.URL + "/engine.io/?EIO=3"
+
+	res, err := http.Get(url + "&transport=polling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Header.Get("Set-Cookie") != "io=handshake" || res.Header.Get("X-Engine") != "goseio" {
+		t.Fatalf("missing headers on the handshake: %v", res.Header)
+	}
+
+	socket := <-sockets
+	socket.SendMessage([]byte("hi"), false)
+	res, err = http.Get(url + "&transport=polling&sid=" + socket.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Header.Get("Set-Cookie") != "" || res.Header.Get("X-Engine") != "goseio" {
+		t.Fatalf("unexpected headers on a poll: %v", res.Header)
+	}
+
+	conn, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if res.Header.Get("Set-Cookie") != "io=handshake" || res.Header.Get("X-Engine") != "goseio" {
+		t.Fatalf("missing headers on the websocket handshake: %v", res.Header)
+	}
+
+	for query, code := range map[string]int{
+		"&transport=carrier-pigeon":      UnknownTransport,
+		"&transport=polling&sid=unknown": UnknownSid,
+	} {
+		res, err := http.Get(url + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		connErr := <-errors
+		if connErr.Code != code || connErr.Request == nil || connErr.Message != errorMessages[code] {
+			t.Fatalf("%s: unexpected connection error %+v", query, connErr)
+		}
+	}
+}
+
+func TestCookie(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Cookie = &CookieOptions{HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	sockets := make(chan *Socket, 2)
+	s.OnConnection(func(socket *Socket) {
+		sockets <- socket
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	url := srv.URL + "/engine.io/?EIO=3"
+
+	res, err := http.Get(url + "&transport=polling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	socket := <-sockets
+	if cookie := res.Header.Get("Set-Cookie"); cookie != "io="+socket.Id+"; Path=/; HttpOnly; SameSite=Lax" {
+		t.Fatalf("unexpected cookie %q", cookie)
+	}
+
+	s.Cookie = &CookieOptions{Name: "sticky", Path: "/engine.io", Secure: true, MaxAge: 60}
+	conn, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	socket = <-sockets
+	if cookie := res.Header.Get("Set-Cookie"); cookie != "sticky="+socket.Id+"; Path=/engine.io; Max-Age=60; Secure" {
+		t.Fatalf("unexpected cookie %q", cookie)
+	}
+}
+
+func TestIDGenerator(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.IDGenerator = func(r *http.Request) (string, error) {
+		return "node1-" + r.URL.Query().Get("user"), nil
+	}
+	errors := make(chan *ConnectionError, 1)
+	s.OnConnectionError(func(err *ConnectionError) {
+		errors <- err
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	url := srv.URL + "/engine.io/?EIO=3&transport=polling&user="
+
+	res, err := http.Get(url + "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(s.Clients()) != 1 || s.Clients()[0].Id != "node1-alice" {
+		t.Fatal("client wasn't created with the generated id")
+	}
+
+	// the id is in use already.
+	res, err = http.Get(url + "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest || (<-errors).Code != BadRequest {
+		t.Fatalf("duplicate id was accepted: %d", res.StatusCode)
+	}
+	if len(s.Clients()) != 1 {
+		t.Fatalf("expected 1 client, got %d", len(s.Clients()))
+	}
+}
+
+func TestTransports(t *testing.T) {
+	cases := []struct {
+		name          string
+		transports    []string
+		allowUpgrades bool
+		upgrades      string
+	}{
+		{"default", []string{"polling", "websocket"}, true, `"upgrades":["websocket"]`},
+		{"no upgrades", []string{"polling", "websocket"}, false, `"upgrades":[]`},
+		{"polling only", []string{"polling"}, true, `"upgrades":[]`},
+	}
+
+	for _, c := range cases {
+		s, err := NewServer("/engine.io", bytes.Buffer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Transports = c.transports
+		s.AllowUpgrades = c.allowUpgrades
+		errors := make(chan *ConnectionError, 1)
+		s.OnConnectionError(func(err *ConnectionError) {
+			errors <- err
+		})
+		srv := httptest.NewServer(s)
+		url := srv.URL + "/engine.io/?EIO=3"
+
+		res, err := http.Get(url + "&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if !strings.Contains(string(body), c.upgrades) {
+			t.Fatalf("%s: expected %s in the open packet %q", c.name, c.upgrades, body)
+		}
+
+		// upgrading is rejected unless it was advertised.
+		sid := string(body[strings.Index(string(body), `"sid":"`)+7:])
+		sid = sid[:strings.IndexByte(sid, '"')]
+		wsURL := "ws" + strings.TrimPrefix(url, "http") + "&transport=websocket&sid=" + sid
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if upgrade := c.upgrades != `"upgrades":[]`; (err == nil) != upgrade {
+			t.Fatalf("%s: upgrade allowed: %v, got %v", c.name, upgrade, err)
+		}
+		if err == nil {
+			conn.Close()
+		} else if connErr := <-errors; connErr.Code == UnknownSid {
+			t.Fatalf("%s: unexpected connection error %+v", c.name, connErr)
+		}
+		srv.Close()
+	}
+
+	// a websocket only server rejects polling.
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Transports = []string{"websocket"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/engine.io/?EIO=3&transport=polling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", res.StatusCode)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+
+		"/engine.io/?EIO=3&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestUpgradeConnectionList(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sockets := make(chan *Socket, 1)
+	s.OnConnection(func(socket *Socket) {
+		sockets <- socket
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/engine.io/?EIO=3&transport=polling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	socket := <-sockets
+
+	// firefox sends the upgrade as part of a list.
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /engine.io/?EIO=3&transport=websocket&sid=%s HTTP/1.1\r\nHost: localhost\r\n"+
+		"Connection: keep-alive, Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", socket.Id)
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status 101, got %d", res.StatusCode)
+	}
+}
@@ -2,14 +2,22 @@ package eio
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"errors"
 	"github.com/adrianmxb/goseio/pkg/eio/packet"
 	"github.com/adrianmxb/goseio/pkg/eio/transport"
+	"github.com/adrianmxb/goseio/pkg/tracing"
 	"net/http"
 	"sync"
 	"time"
 )
 
+// ErrSocketClosed is returned when sending to a socket that is closing or closed.
+var ErrSocketClosed = errors.New("socket closed")
+
+// ErrNoMessageBuffer is returned by Socket.Next if Server.MessageBuffer isn't set.
+var ErrNoMessageBuffer = errors.New("message buffer disabled")
+
 type Socket struct {
 	Id            string
 	server        *Server
@@ -17,8 +25,25 @@ type Socket struct {
 	transportLock sync.RWMutex
 	stateLock     sync.Mutex
 	upgradeState  UpgradeState
+	upgradeSpan   tracing.Span
 	readyState    ReadyState
 	remoteAddr    string
+	closed        chan struct{}
+	closeReason   string
+	closeErr      error
+	ctx           context.Context
+	closeCtx      context.Context
+	cancel        context.CancelFunc
+	messages      chan Message
+	messagesLock  sync.RWMutex
+	dispatcher    dispatcher
+	listeners     listeners
+}
+
+// Message is a message received from the client, see Socket.Messages.
+type Message struct {
+	Data     []byte
+	IsBinary bool
 }
 
 func NewSocket(id string, server *Server, transport transport.ITransport, req *http.Request) *Socket {
@@ -29,12 +54,27 @@ func NewSocket(id string, server *Server, transport transport.ITransport, req *h
 		readyState:   ReadyStateOpening,
 		Transport:    transport,
 		remoteAddr:   req.RemoteAddr,
+		closed:       make(chan struct{}),
+		ctx:          tracing.Detach(req.Context()),
+	}
+	sock.closeCtx, sock.cancel = context.WithCancel(sock.ctx)
+	if server.MessageBuffer > 0 {
+		sock.messages = make(chan Message, server.MessageBuffer)
+	} else if server.Dispatch != DispatchInline {
+		size := server.DispatchQueue
+		if size <= 0 {
+			size = defaultDispatchQueue
+		}
+		sock.dispatcher.queue = make(chan Message, size)
 	}
 
+	transport.OnDrain(sock.onDrain)
+	transport.OnFlush(sock.emitFlush)
 	sock.Open()
 	return sock
 }
 
+// Close closes the socket once the queued messages were sent.
 func (s *Socket) Close() {
 	defer s.stateLock.Unlock()
 	s.stateLock.Lock()
@@ -49,23 +89,212 @@ func (s *Socket) Close() {
 	s.Transport.Close()
 }
 
+// forceClose closes the transport right away, dropping the queued messages.
+func (s *Socket) forceClose() {
+	defer s.transportLock.RUnlock()
+	s.transportLock.RLock()
+	s.Transport.ForceClose()
+}
+
+// TraceContext returns the context of the handshake request, carrying the eio.handshake span.
+// It is never canceled.
+func (s *Socket) TraceContext() context.Context {
+	return s.ctx
+}
+
+// Context returns a context that is canceled once the socket is closed. It carries the values of TraceContext.
+func (s *Socket) Context() context.Context {
+	return s.closeCtx
+}
+
+// Messages returns the channel receiving the messages of the client if Server.MessageBuffer is set, nil otherwise.
+// It is closed once the socket is closed and the remaining messages were received.
+func (s *Socket) Messages() <-chan Message {
+	return s.messages
+}
+
+// Next returns the next message of the client, see Messages. It returns ErrSocketClosed once the socket is closed
+// and the remaining messages were received, and ErrNoMessageBuffer if Server.MessageBuffer isn't set.
+func (s *Socket) Next(ctx context.Context) (Message, error) {
+	if s.messages == nil {
+		return Message{}, ErrNoMessageBuffer
+	}
+	select {
+	case msg, ok := <-s.messages:
+		if !ok {
+			return Message{}, ErrSocketClosed
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// onMessage passes a message received by tsp to MsgHandler, see Server.Dispatch, or to Messages, blocking while
+// the buffer is full.
+func (s *Socket) onMessage(tsp transport.ITransport, data []byte, isBinary bool) {
+	if s.messages == nil {
+		if s.dispatcher.queue != nil {
+			s.dispatch(tsp, data, isBinary)
+		} else {
+			s.server.MsgHandler(s, data, isBinary)
+		}
+		return
+	}
+
+	s.messagesLock.RLock()
+	// messages is closed once closed is.
+	select {
+	case <-s.closed:
+		s.messagesLock.RUnlock()
+		return
+	default:
+	}
+	err := s.enqueue(tsp, s.messages, Message{Data: data, IsBinary: isBinary})
+	s.messagesLock.RUnlock()
+	s.stalled(tsp, err)
+}
+
+// enqueue sends msg to queue in the goroutine reading tsp. While it blocks, pings of the client aren't read,
+// so it gives up with transport.ErrPingTimeout after PingInterval and PingTimeout. It returns ErrSocketClosed or
+// transport.ErrClosed if the socket or tsp closed in the meantime.
+func (s *Socket) enqueue(tsp transport.ITransport, queue chan Message, msg Message) error {
+	select {
+	case queue <- msg:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(s.server.PingInterval + s.server.PingTimeout)
+	defer timer.Stop()
+	select {
+	case queue <- msg:
+		return nil
+	case <-s.closed:
+		return ErrSocketClosed
+	case <-tsp.Done():
+		return transport.ErrClosed
+	case <-timer.C:
+		return transport.ErrPingTimeout
+	}
+}
+
+// stalled closes tsp if enqueue timed out, the socket gets closed with a ping timeout if tsp is its transport.
+// Other errors are reported by the next Recv of tsp.
+func (s *Socket) stalled(tsp transport.ITransport, err error) {
+	if err != transport.ErrPingTimeout {
+		return
+	}
+	s.server.Logger.Debug("message queue stalled", "sid", s.Id, "transport", tsp.GetName())
+	s.transportLock.RLock()
+	current := s.Transport == tsp
+	s.transportLock.RUnlock()
+	if current {
+		s.onClose(err)
+	}
+	tsp.ForceClose()
+}
+
+// endUpgrade ends the span of the pending upgrade, s.stateLock has to be held.
+func (s *Socket) endUpgrade(err error) {
+	if s.upgradeSpan == nil {
+		return
+	}
+	s.upgradeSpan.RecordError(err)
+	s.upgradeSpan.End()
+	s.upgradeSpan = nil
+}
+
+// Closed returns a channel that is closed once the socket is closed.
+func (s *Socket) Closed() <-chan struct{} {
+	return s.closed
+}
+
+// onClose closes the socket because its transport closed with err.
+func (s *Socket) onClose(err error) {
+	s.stateLock.Lock()
+	if s.readyState == ReadyStateClosed {
+		s.stateLock.Unlock()
+		return
+	}
+	reason := CloseReasonTransportError
+	switch {
+	case err == transport.ErrClientClosed:
+		reason, err = CloseReasonTransportClose, nil
+	case err == transport.ErrPingTimeout:
+		reason, err = CloseReasonPingTimeout, nil
+	case s.readyState == ReadyStateClosing:
+		reason, err = CloseReasonForcedClose, nil
+	}
+	s.readyState = ReadyStateClosed
+	s.closeReason, s.closeErr = reason, err
+	s.endUpgrade(ErrSocketClosed)
+	s.stateLock.Unlock()
+
+	s.server.Logger.Debug("closed", "sid", s.Id, "reason", reason, "error", err)
+	if err != nil {
+		s.emitError(err)
+	}
+	s.server.Metrics.Closed(s.TransportName())
+	s.server.remove(s)
+	close(s.closed)
+	s.cancel()
+	if s.messages != nil {
+		// enqueue returns once closed is closed.
+		s.messagesLock.Lock()
+		close(s.messages)
+		s.messagesLock.Unlock()
+	}
+	s.emitClose(reason, err)
+}
+
 var probeBytes = []byte("probe")
 
 func (s *Socket) HandleTransport(transport transport.ITransport, upgrading bool) {
-	stopNoop := make(chan struct{}, 1)
+	defer s.server.wg.Done()
+	// Shutdown waits for the goroutines of the transport as well.
+	defer transport.Wait()
+	stopNoop := make(chan struct{})
+	var stopNoopOnce sync.Once
+	defer stopNoopOnce.Do(func() { close(stopNoop) })
 	for {
 		pack, data, err := transport.Recv()
 		if err != nil {
+			// a failed upgrade doesn't affect the socket.
+			s.transportLock.RLock()
+			current := s.Transport == transport
+			s.transportLock.RUnlock()
+			if current {
+				s.onClose(err)
+			} else if upgrading {
+				s.server.Metrics.UpgradeFailed(transport.GetName())
+				s.stateLock.Lock()
+				s.endUpgrade(err)
+				s.stateLock.Unlock()
+			}
 			return
 		}
+		s.server.Metrics.Received(transport.GetName(), len(data))
+		// packets of an upgrading transport are part of the upgrade.
+		if !upgrading {
+			s.emitPacket(pack, data)
+		}
 		switch pack.PacketType {
 		case packet.Ping:
-			transport.Send(packet.Packet{
+			pong := packet.Packet{
 				PacketType: packet.Pong,
 				IsBinary:   pack.IsBinary,
-			}, data, false)
+			}
+			transport.Send(pong, data, false)
+			if !upgrading {
+				s.emitPacketCreate(pong, data)
+				s.emitHeartbeat()
+			}
 			if upgrading && bytes.Compare(data, probeBytes) == 0 {
+				s.emitUpgrading(transport.GetName())
+				s.server.wg.Add(1)
 				go func() {
+					defer s.server.wg.Done()
 					for {
 						time.Sleep(100 * time.Millisecond)
 						select {
@@ -83,27 +312,35 @@ func (s *Socket) HandleTransport(transport transport.ITransport, upgrading bool)
 				}()
 			}
 		case packet.Message:
-			s.server.MsgHandler(s, data, pack.IsBinary)
+			s.onMessage(transport, data, pack.IsBinary)
 		case packet.Upgrade:
 			s.stateLock.Lock()
 			if s.readyState != ReadyStateClosed {
-				stopNoop <- struct{}{}
+				stopNoopOnce.Do(func() { close(stopNoop) })
 				s.transportLock.Lock()
 				s.Transport.Discard()
+				s.server.Metrics.Upgrade(s.Transport.GetName(), transport.GetName())
 				s.upgradeState = UpgradeStateUpgraded
-				s.Transport.Close()
+				// a pending poll gets answered with a noop.
+				s.Transport.ForceClose()
 				s.Transport = transport
+				transport.OnDrain(s.onDrain)
+				transport.OnFlush(s.emitFlush)
+				s.server.Logger.Debug("upgraded", "sid", s.Id, "transport", transport.GetName())
+				s.endUpgrade(nil)
 				upgrading = false
 				if s.readyState == ReadyStateClosing {
 					transport.Close()
 				}
 				s.transportLock.Unlock()
+				s.stateLock.Unlock()
+				s.emitUpgrade(transport.GetName())
+			} else {
+				s.stateLock.Unlock()
 			}
-			s.stateLock.Unlock()
 		default:
-			fmt.Println("unhandled packet.")
-			fmt.Println(pack)
-			fmt.Println(data)
+			s.server.Logger.Warn("unhandled packet", "sid", s.Id, "transport", transport.GetName(),
+				"type", pack.PacketType)
 		}
 		transport.SetReadDeadline(time.Now().Add(s.server.PingInterval).Add(s.server.PingTimeout))
 	}
@@ -115,31 +352,137 @@ func (s *Socket) Open() {
 	//send open msg
 	openPacket, _ := json.Marshal(&packet.OpenPacket{
 		SID:          s.Id,
-		Upgrades:     []string{"websocket"},
+		Upgrades:     s.server.upgrades(s.Transport.GetName()),
 		PingInterval: s.server.PingInterval.Milliseconds(),
 		PingTimeout:  s.server.PingTimeout.Milliseconds(),
 	})
 
+	s.server.wg.Add(1)
 	go s.HandleTransport(s.Transport, false)
 
-	s.Transport.Send(packet.Packet{
+	open := packet.Packet{
 		PacketType: packet.Open,
 		IsBinary:   false,
-	}, openPacket, false)
+		Compress:   true,
+	}
+	s.Transport.Send(open, openPacket, false)
+	s.emitPacketCreate(open, openPacket)
 
 	if s.server.initialPacket != nil {
-		s.Transport.Send(packet.Packet{
+		initial := packet.Packet{
 			PacketType: packet.Message,
 			IsBinary:   false,
-		}, s.server.initialPacket, false)
+			Compress:   true,
+		}
+		s.Transport.Send(initial, s.server.initialPacket, false)
+		s.emitPacketCreate(initial, s.server.initialPacket)
 	}
 }
 
-func (s *Socket) SendMessage(data []byte, isBinary bool) {
-	defer s.transportLock.RUnlock()
-	s.transportLock.RLock()
-	s.Transport.Send(packet.Packet{
+type SendOptions struct {
+	// Compress allows the transport to compress the message, see Server.PerMessageDeflate and
+	// Server.HttpCompression.
+	Compress bool
+	// Volatile messages may be dropped if the send queue is full, see Server.Overflow.
+	Volatile bool
+	// Callback gets called once the message was written to the websocket or included in a delivered polling
+	// response. Otherwise it gets called with the reason the message never will be, e.g. transport.ErrDropped.
+	Callback func(err error)
+}
+
+// SendMessage sends a message, allowing it to be compressed.
+func (s *Socket) SendMessage(data []byte, isBinary bool) error {
+	return s.Send(data, isBinary, SendOptions{Compress: true})
+}
+
+// SendMessageWait sends a message like SendMessage and returns once it was flushed to the client or
+// won't be sent anymore.
+func (s *Socket) SendMessageWait(data []byte, isBinary bool) error {
+	return <-s.SendAsync(data, isBinary, SendOptions{Compress: true})
+}
+
+// Send queues a message. It returns ErrSocketClosed if the socket is closing, or the error of the transport
+// if the message got dropped right away.
+func (s *Socket) Send(data []byte, isBinary bool, opts SendOptions) error {
+	return s.send(packet.Packet{
 		PacketType: packet.Message,
 		IsBinary:   isBinary,
-	}, data, false)
+		Compress:   opts.Compress,
+		Volatile:   opts.Volatile,
+	}, data, nil, opts.Callback)
+}
+
+// send queues a message, prepared is its encoding shared with other sockets if set.
+func (s *Socket) send(pack packet.Packet, data []byte, prepared *transport.Prepared, callback func(err error)) error {
+	s.stateLock.Lock()
+	open := s.readyState == ReadyStateOpen
+	s.stateLock.Unlock()
+	if !open {
+		if callback != nil {
+			callback(ErrSocketClosed)
+		}
+		return ErrSocketClosed
+	}
+
+	s.transportLock.RLock()
+	name := s.Transport.GetName()
+	err := s.Transport.SendFrame(transport.Frame{
+		Packet:   pack,
+		Data:     data,
+		Prepared: prepared,
+		Done:     callback,
+	}, false)
+	s.transportLock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	s.server.Metrics.Sent(name, len(data))
+	s.emitPacketCreate(pack, data)
+	return nil
+}
+
+// SendAsync sends a message like Send, the returned channel receives the result of its callback.
+func (s *Socket) SendAsync(data []byte, isBinary bool, opts SendOptions) <-chan error {
+	result := make(chan error, 1)
+	callback := opts.Callback
+	opts.Callback = func(err error) {
+		if callback != nil {
+			callback(err)
+		}
+		result <- err
+	}
+	s.Send(data, isBinary, opts)
+	return result
+}
+
+// RemoteAddr returns the address of the client that sent the handshake.
+func (s *Socket) RemoteAddr() string {
+	return s.remoteAddr
+}
+
+// TransportName returns the name of the current transport.
+func (s *Socket) TransportName() string {
+	defer s.transportLock.RUnlock()
+	s.transportLock.RLock()
+	return s.Transport.GetName()
+}
+
+// QueueLen returns the number of packets waiting to be sent to the client.
+func (s *Socket) QueueLen() int {
+	defer s.transportLock.RUnlock()
+	s.transportLock.RLock()
+	return s.Transport.QueueLen()
+}
+
+// Dropped returns the number of packets of the current transport dropped because of Server.Overflow.
+func (s *Socket) Dropped() uint64 {
+	defer s.transportLock.RUnlock()
+	s.transportLock.RLock()
+	return s.Transport.Dropped()
+}
+
+func (s *Socket) onDrain() {
+	s.server.DrainHandler(s)
+	s.emitDrain()
 }
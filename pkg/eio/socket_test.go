@@ -0,0 +1,586 @@
+package eio
+
+import (
+	"bytes"
+	"context"
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"github.com/adrianmxb/goseio/pkg/eio/transport"
+	"github.com/adrianmxb/goseio/pkg/stream"
+	"github.com/gorilla/websocket"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPollingSocket connects a polling client and returns the server side socket and the poll url.
+func newPollingSocket(t *testing.T) (*Socket, string, func()) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sockets := make(chan *Socket, 1)
+	s.OnConnection(func(socket *Socket) {
+		sockets <- socket
+	})
+	srv := httptest.NewServer(s)
+
+	res, err := http.Get(srv.URL + "/engine.io/?EIO=3&transport=polling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	socket := <-sockets
+	return socket, srv.URL + "/engine.io/?EIO=3&transport=polling&sid=" + socket.Id, srv.Close
+}
+
+func poll(t *testing.T, url string) string {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestSendCallback(t *testing.T) {
+	socket, url, closeServer := newPollingSocket(t)
+	defer closeServer()
+
+	result := socket.SendAsync([]byte("hello"), false, SendOptions{})
+	select {
+	case err := <-result:
+		t.Fatalf("callback was called before the message was polled: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if body := poll(t, url); !strings.Contains(body, "4hello") {
+		t.Fatalf("unexpected poll response %q", body)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback wasn't called")
+	}
+}
+
+func TestSendClosed(t *testing.T) {
+	socket, _, closeServer := newPollingSocket(t)
+	defer closeServer()
+
+	socket.Close()
+	if err := socket.SendMessage([]byte("hello"), false); err != ErrSocketClosed {
+		t.Fatalf("expected ErrSocketClosed, got %v", err)
+	}
+	if err := <-socket.SendAsync([]byte("hello"), false, SendOptions{}); err != ErrSocketClosed {
+		t.Fatalf("expected ErrSocketClosed, got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	socket, url, closeServer := newPollingSocket(t)
+	defer closeServer()
+	socket.SendMessage([]byte("bye"), false)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- socket.server.Shutdown(context.Background())
+	}()
+
+	// the queued message is flushed before the close packet.
+	for socket.QueueLen() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if body := poll(t, url); body != "4:4bye1:1" {
+		t.Fatalf("unexpected poll response %q", body)
+	}
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdown didn't return")
+	}
+	select {
+	case <-socket.Closed():
+	default:
+		t.Fatal("socket wasn't closed")
+	}
+
+	res, err := http.Get(strings.Split(url, "?")[0] + "?EIO=3&transport=polling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("handshake was accepted during shutdown: %d", res.StatusCode)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	socket, _, closeServer := newPollingSocket(t)
+	defer closeServer()
+
+	// the client never polls the close packet.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := socket.server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	select {
+	case <-socket.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("socket wasn't closed")
+	}
+}
+
+func TestSocketEvents(t *testing.T) {
+	socket, url, closeServer := newPollingSocket(t)
+	defer closeServer()
+
+	events := make(chan string, 20)
+	socket.OnPacket(func(pack packet.Packet, data []byte) {
+		events <- "packet " + string(pack.ToByte(true))
+	})
+	socket.OnPacketCreate(func(pack packet.Packet, data []byte) {
+		events <- "packetCreate " + string(pack.ToByte(true))
+	})
+	socket.OnHeartbeat(func() {
+		events <- "heartbeat"
+	})
+	socket.OnFlush(func(frames []transport.Frame) {
+		events <- "flush " + strconv.Itoa(len(frames))
+	})
+	socket.OnDrain(func() {
+		events <- "drain"
+	})
+	socket.OnClose(func(reason string, err error) {
+		events <- "close " + reason
+	})
+	next := func() string {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("missing event")
+			return ""
+		}
+	}
+	expect := func(expected ...string) {
+		for _, e := range expected {
+			if event := next(); event != e {
+				t.Fatalf("expected %q, got %q", e, event)
+			}
+		}
+	}
+
+	res, err := http.Post(url, "text/plain;charset=UTF-8", strings.NewReader("1:2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	expect("packet 2", "packetCreate 3", "heartbeat")
+
+	socket.SendMessage([]byte("hi"), false)
+	expect("packetCreate 4")
+	if body := poll(t, url); body != "1:33:4hi" {
+		t.Fatalf("unexpected poll response %q", body)
+	}
+	expect("flush 2", "drain")
+
+	socket.Close()
+	for socket.QueueLen() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	poll(t, url)
+	for event := next(); event != "close "+CloseReasonForcedClose; event = next() {
+	}
+
+	// listeners added to a closed socket are called right away.
+	socket.OnClose(func(reason string, err error) {
+		events <- "late close " + reason
+	})
+	expect("late close " + CloseReasonForcedClose)
+}
+
+func TestConn(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoed := make(chan error, 1)
+	s.OnConnection(func(socket *Socket) {
+		conn := NewConn(socket)
+		go func() {
+			_, err := io.Copy(conn, conn)
+			echoed <- err
+		}()
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// text messages aren't part of the stream.
+	ws.WriteMessage(websocket.TextMessage, []byte("4text"))
+	ws.WriteMessage(websocket.BinaryMessage, []byte("\x04hello"))
+	for {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		typ, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ == websocket.BinaryMessage {
+			if string(msg) != "\x04hello" {
+				t.Fatalf("unexpected echo %q", msg)
+			}
+			break
+		}
+	}
+
+	// closing the socket ends the stream.
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	select {
+	case err := <-echoed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("conn wasn't closed")
+	}
+}
+
+func TestMessages(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.MessageBuffer = 1
+	sockets := make(chan *Socket, 1)
+	s.OnConnection(func(socket *Socket) {
+		sockets <- socket
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	socket := <-sockets
+
+	ws.WriteMessage(websocket.TextMessage, []byte("4first"))
+	ws.WriteMessage(websocket.TextMessage, []byte("4second"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := socket.Next(ctx)
+	if err != nil || string(msg.Data) != "first" || msg.IsBinary {
+		t.Fatalf("unexpected message %q, %v", msg.Data, err)
+	}
+
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	select {
+	case <-socket.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context wasn't canceled")
+	}
+
+	// messages received before the close are still delivered.
+	if msg := <-socket.Messages(); string(msg.Data) != "second" {
+		t.Fatalf("unexpected message %q", msg.Data)
+	}
+	if _, err := socket.Next(ctx); err != ErrSocketClosed {
+		t.Fatalf("expected ErrSocketClosed, got %v", err)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	for _, mode := range []DispatchMode{DispatchSerial, DispatchPool} {
+		s, err := NewServer("/engine.io", bytes.Buffer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Dispatch = mode
+		s.Workers = 1
+		release := make(chan struct{})
+		handled := make(chan string, 3)
+		s.OnMessage(func(socket *Socket, data []byte, isBinary bool) {
+			<-release
+			handled <- string(data)
+		})
+		srv := httptest.NewServer(s)
+
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range []string{"4a", "4b", "4c", "2"} {
+			ws.WriteMessage(websocket.TextMessage, []byte(msg))
+		}
+
+		// the blocked handler doesn't delay the pong.
+		for {
+			ws.SetReadDeadline(time.Now().Add(time.Second))
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatalf("mode %d: %v", mode, err)
+			}
+			if string(msg) == "3" {
+				break
+			}
+		}
+
+		close(release)
+		for _, expected := range []string{"a", "b", "c"} {
+			if msg := <-handled; msg != expected {
+				t.Fatalf("mode %d: expected %q, got %q", mode, expected, msg)
+			}
+		}
+		ws.Close()
+		srv.Close()
+	}
+}
+
+func TestMessagesStalled(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.MessageBuffer = 1
+	s.PingInterval = 50 * time.Millisecond
+	s.PingTimeout = 50 * time.Millisecond
+	sockets := make(chan *Socket, 1)
+	s.OnConnection(func(socket *Socket) {
+		sockets <- socket
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := <-sockets
+	reasons := make(chan string, 1)
+	socket.OnClose(func(reason string, err error) {
+		reasons <- reason
+	})
+
+	// the consumer never reads, the second message blocks the socket until the client disconnects.
+	ws.WriteMessage(websocket.TextMessage, []byte("4first"))
+	ws.WriteMessage(websocket.TextMessage, []byte("4second"))
+	ws.Close()
+
+	select {
+	case <-socket.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context wasn't canceled")
+	}
+	if reason := <-reasons; reason != CloseReasonTransportError && reason != CloseReasonPingTimeout {
+		t.Fatalf("unexpected close reason %q", reason)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDispatchStalled(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Dispatch = DispatchSerial
+	s.DispatchQueue = 1
+	s.PingInterval = 50 * time.Millisecond
+	s.PingTimeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	s.OnMessage(func(socket *Socket, data []byte, isBinary bool) {
+		<-release
+	})
+	closed := make(chan string, 1)
+	s.OnConnection(func(socket *Socket) {
+		socket.OnClose(func(reason string, err error) {
+			closed <- reason
+		})
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// the first message blocks the handler, the second fills the queue and the third blocks the socket, which
+	// can't read the pings of the client anymore.
+	for _, msg := range []string{"4a", "4b", "4c"} {
+		ws.WriteMessage(websocket.TextMessage, []byte(msg))
+	}
+
+	select {
+	case reason := <-closed:
+		if reason != CloseReasonPingTimeout {
+			t.Fatalf("unexpected close reason %q", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("socket wasn't closed")
+	}
+	close(release)
+}
+
+func TestDispatchPoolFairness(t *testing.T) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Dispatch = DispatchPool
+	s.Workers = 1
+	started, release := make(chan struct{}), make(chan struct{})
+	handled := make(chan string, 4)
+	s.OnMessage(func(socket *Socket, data []byte, isBinary bool) {
+		if string(data) == "a1" {
+			close(started)
+			<-release
+		}
+		handled <- string(data)
+	})
+	sockets := make(chan *Socket, 2)
+	s.OnConnection(func(socket *Socket) {
+		sockets <- socket
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+	dial := func() (*websocket.Conn, *Socket) {
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws, <-sockets
+	}
+	a, socketA := dial()
+	defer a.Close()
+	b, socketB := dial()
+	defer b.Close()
+
+	for _, msg := range []string{"4a1", "4a2", "4a3"} {
+		a.WriteMessage(websocket.TextMessage, []byte(msg))
+	}
+	<-started
+	b.WriteMessage(websocket.TextMessage, []byte("4b1"))
+	for deadline := time.Now().Add(time.Second); len(socketA.dispatcher.queue) != 2 ||
+		len(socketB.dispatcher.queue) != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("messages weren't queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the only worker takes turns on the sockets.
+	close(release)
+	for _, expected := range []string{"a1", "b1", "a2", "a3"} {
+		if msg := <-handled; msg != expected {
+			t.Fatalf("expected %q, got %q", expected, msg)
+		}
+	}
+}
+
+// newConnSocket connects a websocket client to a server tunneling a conn through its socket.
+func newConnSocket(t *testing.T, pingInterval, pingTimeout time.Duration) (*websocket.Conn, net.Conn, *Socket, func()) {
+	s, err := NewServer("/engine.io", bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.PingInterval = pingInterval
+	s.PingTimeout = pingTimeout
+	conns := make(chan net.Conn, 1)
+	sockets := make(chan *Socket, 1)
+	s.OnConnection(func(socket *Socket) {
+		conns <- NewConn(socket)
+		sockets <- socket
+	})
+	srv := httptest.NewServer(s)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return ws, <-conns, <-sockets, func() {
+		ws.Close()
+		srv.Close()
+	}
+}
+
+func TestConnBackpressure(t *testing.T) {
+	ws, conn, socket, closeServer := newConnSocket(t, 20*time.Second, 20*time.Second)
+	defer closeServer()
+
+	// the socket stops reading the client until the conn is read.
+	for i := 0; i < stream.ReadBuffer+5; i++ {
+		ws.WriteMessage(websocket.BinaryMessage, []byte("\x04x"))
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-socket.Closed():
+		t.Fatal("a slow reader closed the socket")
+	default:
+	}
+
+	data := make([]byte, stream.ReadBuffer+5)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != strings.Repeat("x", len(data)) {
+		t.Fatalf("read %q", data)
+	}
+}
+
+func TestConnReadBufferFull(t *testing.T) {
+	ws, conn, socket, closeServer := newConnSocket(t, 50*time.Millisecond, 50*time.Millisecond)
+	defer closeServer()
+
+	// nobody reads the conn for longer than a ping period.
+	for i := 0; i <= stream.ReadBuffer; i++ {
+		ws.WriteMessage(websocket.BinaryMessage, []byte("\x04x"))
+	}
+	select {
+	case <-socket.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("socket wasn't closed")
+	}
+
+	data, err := ioutil.ReadAll(conn)
+	if len(data) != stream.ReadBuffer || err != stream.ErrReadBufferFull {
+		t.Fatalf("read %d bytes, %v", len(data), err)
+	}
+}
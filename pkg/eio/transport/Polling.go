@@ -3,13 +3,13 @@ package transport
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
-	"compress/zlib"
-	"fmt"
+	"encoding/json"
 	"github.com/adrianmxb/goseio/pkg/eio/packet"
 	"github.com/adrianmxb/goseio/pkg/eio/parser"
-	"html/template"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
@@ -32,9 +32,13 @@ var payloadBufPool = sync.Pool{
 
 type PollingOptions struct {
 	TransportOptions
-	Type              PollingType
-	MaxHttpBufferSize uint64
-	HttpCompression   bool
+	Type PollingType
+	// MaxHttpBufferSize limits the body of a data request, defaults to DefaultMaxHttpBufferSize.
+	MaxHttpBufferSize      uint64
+	HttpCompression        bool
+	HttpCompressionOptions HttpCompressionOptions
+	// Headers gets called with the headers of every response before they are written.
+	Headers func(headers http.Header, r *http.Request)
 }
 
 type Polling struct {
@@ -57,7 +61,7 @@ func NewPolling(opt PollingOptions, sid string) *Polling {
 		readDeadline: make(chan time.Time),
 	}
 
-	// TODO: is this correct?
+	// JSONP can only transport strings.
 	if opt.Type == JSONP {
 		polling.SupportsBinary = false
 	}
@@ -66,26 +70,24 @@ func NewPolling(opt PollingOptions, sid string) *Polling {
 	polling.pollReady <- true
 	polling.dataReady <- true
 
-	go polling.startModeratorBuddy()
+	polling.spawn(polling.startModeratorBuddy)
 
-	go func() {
-		dead := false
-		deadline := <-polling.readDeadline
+	polling.spawn(func() {
+		var deadline <-chan time.Time
 		for {
-			if dead {
-				deadline = <-polling.readDeadline
-				continue
-			}
-
 			select {
-			case <-time.After(deadline.Sub(time.Now())):
-				dead = true
-				fmt.Println("reached deadline, closing transport. REWORK THIS!")
-				polling.tspModSignal <- "closing"
-			case deadline = <-polling.readDeadline:
+			case t := <-polling.readDeadline:
+				deadline = time.After(t.Sub(time.Now()))
+			case <-deadline:
+				// the client is gone, there is nobody to send a close packet to.
+				polling.logger.Debug("read deadline reached")
+				polling.fail(ErrPingTimeout)
+				return
+			case <-polling.tspModerator:
+				return
 			}
 		}
-	}()
+	})
 
 	return polling
 }
@@ -95,19 +97,15 @@ func (p *Polling) startPayloadWriter() {
 }
 
 func (p *Polling) startModeratorBuddy() {
-	ackClosing := false
+	closing := p.tspClosing
 	for {
 		select {
-		case <-p.tspClosing:
-			if !ackClosing {
-				ackClosing = true
-				p.Send(packet.Packet{
-					PacketType: packet.Close,
-					IsBinary:   false,
-				}, nil, true)
-			}
+		case <-closing:
+			closing = nil
+			p.sendClose()
 
 		case <-p.tspModerator:
+			p.flush(ErrClosed)
 			return
 		}
 	}
@@ -118,8 +116,12 @@ func (p *Polling) GetName() string {
 }
 
 func (p *Polling) SetReadDeadline(t time.Time) error {
-	p.readDeadline <- t
-	return nil
+	select {
+	case <-p.tspModerator:
+		return ErrClosed
+	case p.readDeadline <- t:
+		return nil
+	}
 }
 
 func (p *Polling) SetWriteDeadline(t time.Time) error {
@@ -127,42 +129,45 @@ func (p *Polling) SetWriteDeadline(t time.Time) error {
 }
 
 func (p *Polling) HandlePollRequest(r *http.Request, w http.ResponseWriter) error {
-	pack := <-p.sendPacket
-	data := <-p.sendData
+	// next only fails if the moderator is dead, which gets handled below.
+	frame, _ := p.next()
 	interrupt := false
 
 	select {
 	case <-p.tspModerator:
-		pack = packet.Packet{PacketType: packet.Noop, IsBinary: false}
-		data = nil
+		frame.done(ErrClosed)
+		frame = Frame{Packet: packet.Packet{PacketType: packet.Noop, IsBinary: false}}
 		interrupt = true
 	default:
-		select {
-		case <-p.tspClosing:
-			pack = packet.Packet{PacketType: packet.Close, IsBinary: false}
-			data = nil
-			interrupt = true
-		default:
-		}
 	}
 
-	hasBinary := pack.IsBinary
-	packSlice := append([]packet.Packet{}, pack)
-	dataSlice := append([][]byte{}, data)
-
+	frames := []Frame{frame}
 	if !interrupt {
-	Loop:
-		for {
-			select {
-			case pack := <-p.sendPacket:
-				packSlice = append(packSlice, pack)
-				dataSlice = append(dataSlice, <-p.sendData)
-				if pack.IsBinary {
-					hasBinary = true
-				}
-			default:
-				break Loop
-			}
+		frames = p.batch(frames)
+		p.flushing(frames)
+	}
+
+	err := p.writeFrames(r, w, frames)
+	p.pollReady <- true
+	for _, frame := range frames {
+		frame.done(err)
+	}
+	if err == nil && !interrupt {
+		p.drained()
+	}
+	return err
+}
+
+// writeFrames writes frames as one payload.
+func (p *Polling) writeFrames(r *http.Request, w http.ResponseWriter, frames []Frame) error {
+	hasBinary := false
+	compress := false
+	for _, frame := range frames {
+		if frame.Packet.IsBinary {
+			hasBinary = true
+		}
+		if frame.Packet.Compress {
+			compress = true
 		}
 	}
 
@@ -172,149 +177,161 @@ func (p *Polling) HandlePollRequest(r *http.Request, w http.ResponseWriter) erro
 	buf.Reset()
 	writer := bufio.NewWriter(buf)
 
-	preparedWriter := parser.PrepareWriter(writer, hasBinary, p.SupportsBinary)
-	for i, pack := range packSlice {
-		length, err := parser.EncodePayloadLength(writer, pack, dataSlice[i], p.SupportsBinary)
-		if err != nil {
-			return err
+	for _, frame := range frames {
+		var err error
+		if frame.Prepared != nil {
+			var encoded []byte
+			if encoded, err = frame.Prepared.encode(pollingVariant, p.SupportsBinary); err == nil {
+				_, err = writer.Write(encoded)
+			}
+		} else {
+			err = encodePayloadPacket(writer, frame.Packet, frame.Data, p.SupportsBinary)
 		}
-		w.Header().Set("Content-Length", strconv.Itoa(length))
-		err = parser.WriteHeader(writer, pack, p.SupportsBinary)
 		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
 			return err
 		}
-		if _, err := preparedWriter.Write(dataSlice[i]); err != nil {
-			return err
-		}
-		preparedWriter.Close()
 	}
 	writer.Flush()
 
-	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
-
+	body := buf.Bytes()
 	if p.PollingOptions.Type == JSONP {
-		buf := bytes.NewBuffer(nil)
-		writer := bufio.NewWriter(buf)
-		jsonp := r.URL.Query().Get("j")
-
+		wrapped, err := wrapJSONP(r.URL.Query().Get("j"), body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
+		body = wrapped
 		w.Header().Set("Content-Type", "text/javascript; charset=UTF-8")
-		p.pollReady <- true
-		w.Write([]byte("___eio[" + jsonp + "](\""))
-		w.Write(buf.Bytes())
-		writer.Flush()
-
-		template.JSEscape(w, buf.Bytes())
-		w.Write([]byte("\");"))
-		return nil
-	}
-
-	//TODO: make compression threshold configurable
-	COMPRESSION_THRESHOLD := 1024
-	if hasBinary {
+	} else if hasBinary {
 		w.Header().Set("Content-Type", "application/octet-stream")
 	} else {
 		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 	}
 
-	if !p.PollingOptions.HttpCompression || buf.Len() < COMPRESSION_THRESHOLD {
-		p.pollReady <- true
-		w.Write(buf.Bytes())
-		return nil
-	}
+	return p.writeBody(r, w, body, compress)
+}
 
-	encodingSupported := ""
-	for _, encoding := range r.TransferEncoding {
-		if encoding == "gzip" || encoding == "deflate" {
-			encodingSupported = encoding
-			break
+// wrapJSONP wraps the payload into the javascript callback engine.io-client expects:
+// ___eio[j]("payload");
+func wrapJSONP(j string, payload []byte) ([]byte, error) {
+	// only digits are allowed in the callback index, everything else would allow script injection.
+	index := make([]byte, 0, len(j))
+	for i := 0; i < len(j); i++ {
+		if j[i] >= '0' && j[i] <= '9' {
+			index = append(index, j[i])
 		}
 	}
 
-	ebuf := new(bytes.Buffer)
-	switch encodingSupported {
-	case "gzip":
-		writer := gzip.NewWriter(ebuf)
-		if _, err := writer.Write(buf.Bytes()); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return err
-		}
-		writer.Close()
-	case "deflate":
-		writer := zlib.NewWriter(ebuf)
-		if _, err := writer.Write(buf.Bytes()); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return err
-		}
-		writer.Close()
+	// json escapes U+2028 and U+2029 as well, so the result is valid javascript and not just valid json.
+	js, err := json.Marshal(string(payload))
+	if err != nil {
+		return nil, err
 	}
-	w.Header().Set("Content-Encoding", encodingSupported)
 
-	p.pollReady <- true
-	w.Write(ebuf.Bytes())
-	return nil
+	wrapped := make([]byte, 0, len(js)+len(index)+10)
+	wrapped = append(wrapped, "___eio["...)
+	wrapped = append(wrapped, index...)
+	wrapped = append(wrapped, "]("...)
+	wrapped = append(wrapped, js...)
+	wrapped = append(wrapped, ");"...)
+	return wrapped, nil
+}
+
+var regexSlashes = regexp.MustCompile(`(\\)?\\n`)
+var regexDoubleSlashes = regexp.MustCompile(`\\\\n`)
+
+// unescapeJSONP reverts the escaping engine.io-client applies to JSONP form data:
+// real newlines are sent as \n and escaped newlines (\n) as \\n.
+func unescapeJSONP(data string) string {
+	data = regexSlashes.ReplaceAllStringFunc(data, func(match string) string {
+		if len(match) == 3 {
+			return match
+		}
+		return "\n"
+	})
+	return regexDoubleSlashes.ReplaceAllString(data, `\n`)
 }
 
-var regexSlashes, _ = regexp.Compile(`/(\\)?\\n/g`)
-var regexDoubleSlashes, _ = regexp.Compile(`/\\\\n/g`)
+// DefaultMaxHttpBufferSize limits the body of a data request if PollingOptions.MaxHttpBufferSize isn't set,
+// like engine.io.
+const DefaultMaxHttpBufferSize = 10e7
 
 func (p *Polling) HandleDataRequest(r *http.Request, w http.ResponseWriter) {
-	//isBinary := r.Header.Get("content-type") == "application/octet-stream"
-	length, err := strconv.Atoi(r.Header.Get("Content-Length"))
-	if err != nil {
+	// the data of a closing or closed transport would never be read.
+	select {
+	case <-p.tspClosing:
 		p.dataReady <- true
+		w.WriteHeader(http.StatusBadRequest)
 		return
+	case <-p.tspModerator:
+		p.dataReady <- true
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	default:
 	}
 
-	//this sucks and is really expensive.
-	if p.PollingOptions.Type == JSONP {
-		/*
-			body, _ := ioutil.ReadAll(r.Body)
-			parsedBody, _ := url.ParseQuery(string(body))
-			data := parsedBody.Get("d")
-		*/
-
-		//TODO: regex!
-		/*
-		   data = data.replace(rSlashes, function (match, slashes) {
-		     return slashes ? match : '\n';
-		   });
-		   Polling.prototype.onData.call(this, data.replace(rDoubleSlashes, '\\n'));
-		*/
-		fmt.Println("JSONP HandleDataRequest not implemented, regex missing! feel free to implement.")
+	limit := p.PollingOptions.MaxHttpBufferSize
+	if limit == 0 || limit > math.MaxInt64 {
+		limit = DefaultMaxHttpBufferSize
+	}
+	//isBinary := r.Header.Get("content-type") == "application/octet-stream"
+	length, err := strconv.Atoi(r.Header.Get("Content-Length"))
+	if err != nil || length < 0 || uint64(length) > limit {
 		p.dataReady <- true
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	readers, err := parser.DecodePayload(r.Body, length)
+	// Content-Length is up to the client, the body is read until limit instead of allocated from it.
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(limit)))
 	if err != nil {
-		//what now?
+		p.dataReady <- true
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	select {
-	case <-p.tspClosing:
+	if p.PollingOptions.Type == JSONP {
+		// the payload is posted as form field d.
+		values, err := url.ParseQuery(string(payload))
+		if err != nil {
+			p.dataReady <- true
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		payload = []byte(unescapeJSONP(values.Get("d")))
+	}
+
+	readers, err := parser.DecodePayload(bytes.NewReader(payload), len(payload))
+	if err != nil {
 		p.dataReady <- true
+		w.WriteHeader(http.StatusBadRequest)
 		return
-	default:
 	}
 
-	go func() {
+	p.spawn(func() {
 		for _, reader := range readers {
 			pack, data, err := parser.AnalyzeReader(reader)
 			if err != nil {
 				continue
 			}
 
-			if pack.PacketType == packet.Close {
-				//handle close?
+			select {
+			case <-p.tspModerator:
+				return
+			case p.recvPacket <- *pack:
+				p.recvData <- data
 			}
-
-			p.recvPacket <- *pack
-			p.recvData <- data
 		}
-	}()
+	})
 
+	if p.PollingOptions.Type == JSONP {
+		w.Header().Set("Content-Type", "text/html")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	}
 	p.dataReady <- true
+	w.Write([]byte("ok"))
 }
 
 func (p *Polling) HandleRequest(r *http.Request, w http.ResponseWriter) {
@@ -360,4 +377,8 @@ func (p *Polling) SetHeaders(r *http.Request, w http.ResponseWriter) {
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
 	}
+
+	if p.PollingOptions.Headers != nil {
+		p.PollingOptions.Headers(w.Header(), r)
+	}
 }
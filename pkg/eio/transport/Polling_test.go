@@ -0,0 +1,203 @@
+package transport
+
+import (
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newJSONP() *Polling {
+	return NewPolling(PollingOptions{
+		TransportOptions: TransportOptions{SupportsBinary: true},
+		Type:             JSONP,
+	}, "FhyF1GPVhtcnHAsaAAAA")
+}
+
+func TestJSONPPollRequest(t *testing.T) {
+	p := newJSONP()
+	p.Send(packet.Packet{PacketType: packet.Open},
+		[]byte(`{"sid":"FhyF1GPVhtcnHAsaAAAA","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000}`), false)
+	p.Send(packet.Packet{PacketType: packet.Message}, []byte("0"), false)
+
+	r := httptest.NewRequest("GET", "/engine.io/?EIO=3&transport=polling&j=0&t=1489590390431-0", nil)
+	w := httptest.NewRecorder()
+	p.HandleRequest(r, w)
+
+	// the handshake payload of an engine.io 3.x server for a JSONP client.
+	expected := `___eio[0]("96:0{\"sid\":\"FhyF1GPVhtcnHAsaAAAA\",\"upgrades\":[\"websocket\"],\"pingInterval\":25000,\"pingTimeout\":5000}2:40");`
+	if body := w.Body.String(); body != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/javascript; charset=UTF-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(len(expected)) {
+		t.Fatalf("unexpected content length %q", cl)
+	}
+}
+
+func TestJSONPEscaping(t *testing.T) {
+	p := newJSONP()
+	p.Send(packet.Packet{PacketType: packet.Message}, []byte("a\"\\\n\u2028</script>"), false)
+
+	r := httptest.NewRequest("GET", "/engine.io/?EIO=3&transport=polling&j=1)%3Balert(1", nil)
+	w := httptest.NewRecorder()
+	p.HandleRequest(r, w)
+
+	expected := `___eio[11]("15:4a\"\\\n\u2028\u003c/script\u003e");`
+	if body := w.Body.String(); body != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, body)
+	}
+}
+
+// jsonpExchange is a request of socket.io-client 1.3.7 (engine.io-client 1.5, EIO=3) running with forceJSONP
+// against this server, and the response the client accepted.
+type jsonpExchange struct {
+	method, url, body string
+	response          string
+}
+
+// recorded while the client emitted "echo" with a few strings, got them echoed back and disconnected.
+var recordedJSONP = []jsonpExchange{
+	{"GET", "/socket.io/?EIO=3&transport=polling&j=0&t=1792423111686-0&b64=1", "",
+		`___eio[0]("97:0{\"sid\":\"FhyF1GPVhtcnHAsaAAAA\",\"upgrades\":[\"websocket\"],\"pingInterval\":20000,\"pingTimeout\":20000}2:40");`},
+	{"POST", "/socket.io/?EIO=3&transport=polling&j=0&t=1792423111700-1&b64=1&sid=FhyF1GPVhtcnHAsaAAAA",
+		`d=91%3A42%5B%22echo%22%2C%22line%5C%5Cnbreak%22%2C%22escaped+%5C%5C%5Cn+newline%22%2C%22quote+%5C%22+and+%3C%2Fscript%3E%22%2C%22plus%2Bamp%26percent%25%22%5D`,
+		"ok"},
+	{"GET", "/socket.io/?EIO=3&transport=polling&j=0&t=1792423111702-2&b64=1&sid=FhyF1GPVhtcnHAsaAAAA", "",
+		`___eio[0]("106:42[\"echo\",\"line\\nbreak\",\"escaped \\\\n newline\",\"quote \\\" and \\u003c/script\\u003e\",\"plus+amp\\u0026percent%\"]");`},
+	{"POST", "/socket.io/?EIO=3&transport=polling&j=0&t=1792423111708-3&b64=1&sid=FhyF1GPVhtcnHAsaAAAA", "d=2%3A41", "ok"},
+	{"POST", "/socket.io/?EIO=3&transport=polling&j=0&t=1792423111710-5&b64=1&sid=FhyF1GPVhtcnHAsaAAAA", "d=1%3A1", "ok"},
+}
+
+func postJSONP(p *Polling, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/socket.io/?EIO=3&transport=polling&j=0&sid=FhyF1GPVhtcnHAsaAAAA", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	w := httptest.NewRecorder()
+	p.HandleRequest(r, w)
+	return w
+}
+
+func TestJSONPRecorded(t *testing.T) {
+	p := newJSONP()
+
+	// what the server sent and received around the recorded requests.
+	sent := [][]byte{
+		[]byte(`{"sid":"FhyF1GPVhtcnHAsaAAAA","upgrades":["websocket"],"pingInterval":20000,"pingTimeout":20000}`),
+		nil,
+		[]byte(`2["echo","line\nbreak","escaped \\n newline","quote \" and \u003c/script\u003e","plus+amp\u0026percent%"]`),
+	}
+	received := []struct {
+		packetType packet.PacketType
+		data       string
+	}{
+		{packet.Message, `2["echo","line\nbreak","escaped \\n newline","quote \" and </script>","plus+amp&percent%"]`},
+		{packet.Message, "1"},
+		{packet.Close, ""},
+	}
+
+	for i, exchange := range recordedJSONP {
+		var r *http.Request
+		if exchange.method == "GET" {
+			if i == 0 {
+				p.Send(packet.Packet{PacketType: packet.Open}, sent[0], false)
+				p.Send(packet.Packet{PacketType: packet.Message}, []byte("0"), false)
+			} else {
+				p.Send(packet.Packet{PacketType: packet.Message}, sent[i], false)
+			}
+			r = httptest.NewRequest("GET", exchange.url, nil)
+		} else {
+			r = httptest.NewRequest("POST", exchange.url, strings.NewReader(exchange.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Content-Length", strconv.Itoa(len(exchange.body)))
+		}
+		w := httptest.NewRecorder()
+		p.HandleRequest(r, w)
+		if w.Code != http.StatusOK || w.Body.String() != exchange.response {
+			t.Fatalf("%s %s: expected\n%s\ngot %d\n%s", exchange.method, exchange.url, exchange.response, w.Code, w.Body.String())
+		}
+
+		if exchange.method == "POST" {
+			expected := received[0]
+			received = received[1:]
+			pack, data, err := p.Recv()
+			if expected.packetType == packet.Close {
+				if err != ErrClientClosed {
+					t.Fatalf("expected the client to close, got %v %v", pack, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pack.PacketType != expected.packetType || string(data) != expected.data {
+				t.Fatalf("expected %v %q, got %v %q", expected.packetType, expected.data, pack.PacketType, data)
+			}
+		}
+	}
+}
+
+func TestJSONPInvalidDataRequest(t *testing.T) {
+	p := NewPolling(PollingOptions{Type: JSONP, MaxHttpBufferSize: 32}, "FhyF1GPVhtcnHAsaAAAA")
+
+	for name, request := range map[string]struct {
+		body          string
+		contentLength string
+	}{
+		"negative length":  {"d=1%3A1", "-1"},
+		"missing length":   {"d=1%3A1", ""},
+		"oversized length": {"d=1%3A1", "33"},
+		// the client claims less than it sends.
+		"oversized body": {"d=" + strings.Repeat("a", 64), "7"},
+		"invalid form":   {"d=%ZZ", "5"},
+		"invalid length": {"d=9%3A1", "7"},
+		"invalid packet": {"d=x", "3"},
+	} {
+		r := httptest.NewRequest("POST", "/engine.io/?EIO=3&transport=polling&j=0&sid=FhyF1GPVhtcnHAsaAAAA", strings.NewReader(request.body))
+		r.Header.Set("Content-Length", request.contentLength)
+		w := httptest.NewRecorder()
+		p.HandleRequest(r, w)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected bad request, got %d", name, w.Code)
+		}
+	}
+
+	// the transport still takes the next request.
+	if w := postJSONP(p, "d=2%3A4a"); w.Code != http.StatusOK {
+		t.Fatalf("expected ok, got %d", w.Code)
+	}
+	if pack, data, err := p.Recv(); err != nil || pack.PacketType != packet.Message || string(data) != "a" {
+		t.Fatalf("unexpected packet %v %q %v", pack, data, err)
+	}
+}
+
+func TestJSONPDataRequestAfterClose(t *testing.T) {
+	p := newJSONP()
+	p.ForceClose()
+	<-p.Done()
+
+	for i := 0; i < 2; i++ {
+		if w := postJSONP(p, "d=2%3A4a"); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected bad request after close, got %d", w.Code)
+		}
+	}
+}
+
+func TestUnescapeJSONP(t *testing.T) {
+	cases := map[string]string{
+		`a\nb`:     "a\nb",
+		`a\\nb`:    `a\nb`,
+		`a\\\nb`:   `a\\nb`,
+		`\n\\n\n`:  "\n\\n\n",
+		`no lines`: "no lines",
+	}
+	for in, expected := range cases {
+		if out := unescapeJSONP(in); out != expected {
+			t.Errorf("unescapeJSONP(%q): expected %q, got %q", in, expected, out)
+		}
+	}
+}
@@ -2,17 +2,80 @@ package transport
 
 import (
 	"errors"
+	"github.com/adrianmxb/goseio/pkg/debug"
 	"github.com/adrianmxb/goseio/pkg/eio/packet"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultQueueSize is the number of packets a transport buffers until its overflow policy applies.
+const DefaultQueueSize = 30
+
+// OverflowPolicy decides what happens to a packet sent to a transport with a full send queue.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the sender until there is space in the queue or QueueTimeout is reached,
+	// in which case the packet gets dropped.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued packet to make space for the new one. Forced and close packets
+	// are never dropped, while one of them is queued the sender blocks like with OverflowBlock.
+	OverflowDropOldest
+	// OverflowDropVolatile drops volatile packets and blocks like OverflowBlock for all others.
+	OverflowDropVolatile
+	// OverflowDisconnect closes the transport of the slow client and drops the packet.
+	OverflowDisconnect
+)
+
+var ErrDropped = errors.New("packet dropped because the send queue is full")
+var ErrClosed = errors.New("transport closed")
+
+// ErrClientClosed is returned by Recv if the client closed the transport.
+var ErrClientClosed = errors.New("transport closed by client")
+
+// ErrPingTimeout is returned by Recv if the client didn't send anything until the read deadline.
+var ErrPingTimeout = errors.New("ping timeout")
+
+// Frame is a packet queued for sending together with its data.
+type Frame struct {
+	Packet packet.Packet
+	Data   []byte
+	// Prepared is the encoding of Packet and Data shared with other transports, if set.
+	Prepared *Prepared
+	// Done gets called once the frame was written to the connection, or with ErrDropped or ErrClosed if it
+	// never will be.
+	Done func(err error)
+
+	forced bool
+}
+
+func (f *Frame) done(err error) {
+	if f.Done != nil {
+		f.Done(err)
+	}
+}
+
+// evictable reports whether OverflowDropOldest may drop the frame.
+func (f *Frame) evictable() bool {
+	return !f.forced && f.Packet.PacketType != packet.Close
+}
+
 type TransportOptions struct {
+	// Logger defaults to debug.Nop.
+	Logger         debug.Logger
 	SupportsBinary bool
+	// QueueSize defaults to DefaultQueueSize.
+	QueueSize int
+	Overflow  OverflowPolicy
+	// QueueTimeout limits how long OverflowBlock blocks, 0 blocks until the transport is closed.
+	QueueTimeout time.Duration
 }
 
 type Transport struct {
 	SupportsBinary bool
+	logger         debug.Logger
 
 	tspModSignal chan string
 	//if this channel is alive, everything is fine.
@@ -20,19 +83,47 @@ type Transport struct {
 	tspActive    chan struct{}
 	tspClosing   chan struct{}
 
-	sendPacket chan packet.Packet
-	sendData   chan []byte
+	overflow     OverflowPolicy
+	queueTimeout time.Duration
+	dropped      uint64
+	sendQueue    chan Frame
+
+	// protected counts the queued frames that can't be evicted, evictLock keeps them from being queued while
+	// another sender evicts.
+	evictLock sync.Mutex
+	protected int32
+
+	hooksLock sync.RWMutex
+	drain     func()
+	flushed   func(frames []Frame)
+
+	errLock sync.Mutex
+	err     error
 
 	recvPacket chan packet.Packet
 	recvData   chan []byte
+
+	// wg tracks the goroutines of the transport, see Wait.
+	wgLock  sync.Mutex
+	wg      sync.WaitGroup
+	waiting bool
 }
 
 type ITransport interface {
 	GetName() string
 	Discard()
 	Close()
+	ForceClose()
+	Done() <-chan struct{}
+	Wait()
 	Recv() (packet.Packet, []byte, error)
 	Send(pack packet.Packet, data []byte, force bool) bool
+	SendFrame(frame Frame, force bool) error
+	QueueLen() int
+	Dropped() uint64
+	Err() error
+	OnDrain(fn func())
+	OnFlush(fn func(frames []Frame))
 	//
 	SetReadDeadline(t time.Time) error
 	SetWriteDeadline(t time.Time) error
@@ -41,8 +132,16 @@ type ITransport interface {
 }
 
 func NewTransport(opt TransportOptions) *Transport {
+	if opt.QueueSize <= 0 {
+		opt.QueueSize = DefaultQueueSize
+	}
+	if opt.Logger == nil {
+		opt.Logger = debug.Nop
+	}
+
 	transport := &Transport{
 		SupportsBinary: opt.SupportsBinary,
+		logger:         opt.Logger,
 
 		tspModSignal: make(chan string, 1),
 
@@ -55,15 +154,17 @@ func NewTransport(opt TransportOptions) *Transport {
 		// ^= closing
 		tspClosing: make(chan struct{}),
 
-		sendPacket: make(chan packet.Packet, 30),
-		sendData:   make(chan []byte, 30),
+		overflow:     opt.Overflow,
+		queueTimeout: opt.QueueTimeout,
+		sendQueue:    make(chan Frame, opt.QueueSize),
+
 		recvPacket: make(chan packet.Packet),
 		recvData:   make(chan []byte),
 	}
 
 	//run the moderator!
 	//this little fellow takes care of altering transport state.
-	go func() {
+	transport.spawn(func() {
 		active := true
 		sending := true
 		for {
@@ -71,6 +172,7 @@ func NewTransport(opt TransportOptions) *Transport {
 
 			switch signal {
 			case "close":
+				transport.logger.Debug("transport closed")
 				close(transport.tspModerator)
 				return
 			case "discard":
@@ -85,58 +187,344 @@ func NewTransport(opt TransportOptions) *Transport {
 				}
 			}
 		}
-	}()
+	})
 
 	return transport
 }
 
+// spawn runs fn in a goroutine Wait waits for. Once Wait was called, fn isn't run anymore.
+func (t *Transport) spawn(fn func()) bool {
+	defer t.wgLock.Unlock()
+	t.wgLock.Lock()
+	if t.waiting {
+		return false
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		fn()
+	}()
+	return true
+}
+
+// Wait blocks until the transport is closed and its goroutines exited.
+func (t *Transport) Wait() {
+	<-t.tspModerator
+	t.wgLock.Lock()
+	t.waiting = true
+	t.wgLock.Unlock()
+	t.wg.Wait()
+}
+
+// signal sends a signal to the moderator, unless it is dead already.
+func (t *Transport) signal(signal string) {
+	select {
+	case <-t.tspModerator:
+	case t.tspModSignal <- signal:
+	}
+}
+
 func (t *Transport) Discard() {
-	t.tspModSignal <- "discard"
+	t.signal("discard")
 }
 
+// Close closes the transport gracefully, after the queued packets and a close packet were sent.
 func (t *Transport) Close() {
-	t.tspModSignal <- "closing"
+	t.signal("closing")
+}
+
+// ForceClose closes the transport right away, queued packets get dropped.
+func (t *Transport) ForceClose() {
+	t.signal("close")
+}
+
+// fail closes the transport right away because of err.
+func (t *Transport) fail(err error) {
+	t.setErr(err)
+	t.ForceClose()
+}
+
+// setErr records the reason the transport closed, only the first one is kept. Errors of an already closed
+// transport, e.g. reading from the connection closed by the server, are ignored.
+func (t *Transport) setErr(err error) {
+	select {
+	case <-t.tspModerator:
+		return
+	default:
+	}
+
+	defer t.errLock.Unlock()
+	t.errLock.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// Err returns the reason the transport closed, e.g. ErrClientClosed or ErrPingTimeout.
+// It is nil while the transport is open or if it was closed by the server.
+func (t *Transport) Err() error {
+	defer t.errLock.Unlock()
+	t.errLock.Lock()
+	return t.err
+}
+
+// Done returns a channel that is closed once the transport is closed.
+func (t *Transport) Done() <-chan struct{} {
+	return t.tspModerator
+}
+
+// sendClose queues a close packet and closes the transport once it was sent.
+func (t *Transport) sendClose() {
+	t.SendFrame(Frame{
+		Packet: packet.Packet{PacketType: packet.Close},
+		Done: func(err error) {
+			t.ForceClose()
+		},
+	}, true)
 }
 
 var recvErr = errors.New("recv channel closed")
-var closeErr = errors.New("got close packet")
 
+// Recv returns the next packet of the client. Once the transport is closed, it returns the error of Err,
+// or a generic one if the transport was closed by the server.
 func (t *Transport) Recv() (packet.Packet, []byte, error) {
-	recvPacket, ok := <-t.recvPacket
+	var recvPacket packet.Packet
+	var ok bool
+	select {
+	case recvPacket, ok = <-t.recvPacket:
+	case <-t.tspModerator:
+		return recvPacket, nil, t.recvErr()
+	}
 
 	if !ok {
-		return recvPacket, <-t.recvData, recvErr
+		return recvPacket, <-t.recvData, t.recvErr()
 	}
 
 	if recvPacket.PacketType == packet.Close {
-		t.tspModSignal <- "close"
-		return recvPacket, <-t.recvData, closeErr
+		t.fail(ErrClientClosed)
+		return recvPacket, <-t.recvData, ErrClientClosed
 	}
 
 	return recvPacket, <-t.recvData, nil
 }
 
+func (t *Transport) recvErr() error {
+	if err := t.Err(); err != nil {
+		return err
+	}
+	return recvErr
+}
+
+// Send queues a packet, it returns false if the packet got dropped.
 func (t *Transport) Send(pack packet.Packet, data []byte, force bool) bool {
+	return t.SendFrame(Frame{Packet: pack, Data: data}, force) == nil
+}
+
+// SendFrame queues a frame, it returns ErrDropped or ErrClosed if the frame won't be sent. In that case Done
+// was called with the same error already.
+func (t *Transport) SendFrame(frame Frame, force bool) error {
+	frame.forced = force
+	if frame.evictable() {
+		return t.enqueue(frame, force)
+	}
+
+	t.evictLock.Lock()
+	atomic.AddInt32(&t.protected, 1)
+	t.evictLock.Unlock()
+	err := t.enqueue(frame, force)
+	if err != nil {
+		atomic.AddInt32(&t.protected, -1)
+	}
+	return err
+}
+
+func (t *Transport) enqueue(frame Frame, force bool) error {
 	select {
 	case <-t.tspModerator:
 		//moderator is dead.
-		return false
+		frame.done(ErrClosed)
+		return ErrClosed
 		//make sure no new requests get sent to closed connection.
 	case <-t.tspClosing:
 		if force == true {
 			break
 		}
-		return false
+		frame.done(ErrClosed)
+		return ErrClosed
 	default:
 	}
 
+	select {
+	case t.sendQueue <- frame:
+		return nil
+	default:
+	}
+
+	// the queue is full, forced packets are always waited for.
+	t.logger.Debug("send queue full", "policy", t.overflow, "size", cap(t.sendQueue))
+	policy := t.overflow
+	if force {
+		policy = OverflowBlock
+	}
+
+	switch policy {
+	case OverflowDropOldest:
+	evicting:
+		for {
+			select {
+			case t.sendQueue <- frame:
+				return nil
+			case <-t.tspModerator:
+				frame.done(ErrClosed)
+				return ErrClosed
+			default:
+			}
+
+			// other senders might fill up the queue again, so try until it worked.
+			if !t.evict() {
+				break evicting
+			}
+		}
+	case OverflowDropVolatile:
+		if frame.Packet.Volatile {
+			atomic.AddUint64(&t.dropped, 1)
+			frame.done(ErrDropped)
+			return ErrDropped
+		}
+	case OverflowDisconnect:
+		t.logger.Warn("disconnecting slow client")
+		atomic.AddUint64(&t.dropped, 1)
+		frame.done(ErrDropped)
+		t.fail(ErrDropped)
+		return ErrDropped
+	}
+
+	var timeout <-chan time.Time
+	if t.queueTimeout > 0 && !force {
+		timer := time.NewTimer(t.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
 	//process old requests, abort only if connection was closed by force.
 	select {
 	case <-t.tspModerator:
+		frame.done(ErrClosed)
+		return ErrClosed
+	case <-timeout:
+		atomic.AddUint64(&t.dropped, 1)
+		frame.done(ErrDropped)
+		return ErrDropped
+	case t.sendQueue <- frame:
+		return nil
+	}
+}
+
+// evict drops the oldest queued frame. It returns false without dropping anything if a forced or close frame
+// is queued, since the oldest frame might be that one.
+func (t *Transport) evict() bool {
+	t.evictLock.Lock()
+	if atomic.LoadInt32(&t.protected) > 0 {
+		t.evictLock.Unlock()
 		return false
-	case t.sendPacket <- pack:
-		t.sendData <- data
-		return true
+	}
+	var old Frame
+	evicted := false
+	select {
+	case old = <-t.sendQueue:
+		evicted = true
+	default:
+	}
+	t.evictLock.Unlock()
+
+	if evicted {
+		atomic.AddUint64(&t.dropped, 1)
+		old.done(ErrDropped)
+	}
+	return true
+}
+
+// dequeued updates the count of protected frames once frame left the queue.
+func (t *Transport) dequeued(frame Frame) Frame {
+	if !frame.evictable() {
+		atomic.AddInt32(&t.protected, -1)
+	}
+	return frame
+}
+
+// next returns the next queued frame, ok is false if the transport got closed while waiting.
+func (t *Transport) next() (frame Frame, ok bool) {
+	select {
+	case <-t.tspModerator:
+		return frame, false
+	case frame = <-t.sendQueue:
+		return t.dequeued(frame), true
+	}
+}
+
+// batch appends every queued frame to frames without waiting.
+func (t *Transport) batch(frames []Frame) []Frame {
+	for {
+		select {
+		case frame := <-t.sendQueue:
+			frames = append(frames, t.dequeued(frame))
+		default:
+			return frames
+		}
+	}
+}
+
+// flush completes every queued frame with err, e.g. once the connection is gone.
+func (t *Transport) flush(err error) {
+	for _, frame := range t.batch(nil) {
+		frame.done(err)
+	}
+}
+
+// QueueLen returns the number of packets waiting to be sent.
+func (t *Transport) QueueLen() int {
+	return len(t.sendQueue)
+}
+
+// Dropped returns the number of packets dropped because of the overflow policy.
+func (t *Transport) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// OnDrain sets a function that gets called every time the send queue was flushed completely.
+func (t *Transport) OnDrain(fn func()) {
+	defer t.hooksLock.Unlock()
+	t.hooksLock.Lock()
+	t.drain = fn
+}
+
+// OnFlush sets a function that gets called with the frames that are about to be written to the client.
+func (t *Transport) OnFlush(fn func(frames []Frame)) {
+	defer t.hooksLock.Unlock()
+	t.hooksLock.Lock()
+	t.flushed = fn
+}
+
+func (t *Transport) drained() {
+	if len(t.sendQueue) != 0 {
+		return
+	}
+
+	t.hooksLock.RLock()
+	fn := t.drain
+	t.hooksLock.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+func (t *Transport) flushing(frames []Frame) {
+	t.hooksLock.RLock()
+	fn := t.flushed
+	t.hooksLock.RUnlock()
+	if fn != nil {
+		fn(frames)
 	}
 }
 
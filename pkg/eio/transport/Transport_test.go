@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type sender interface {
+	Send(pack packet.Packet, data []byte, force bool) bool
+}
+
+func send(tsp sender, data string, volatile bool) bool {
+	return tsp.Send(packet.Packet{PacketType: packet.Message, Volatile: volatile}, []byte(data), false)
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	tsp := NewTransport(TransportOptions{QueueSize: 2, Overflow: OverflowDropOldest})
+	for _, data := range []string{"a", "b", "c"} {
+		if !send(tsp, data, false) {
+			t.Fatalf("%s got dropped", data)
+		}
+	}
+
+	if tsp.QueueLen() != 2 || tsp.Dropped() != 1 {
+		t.Fatalf("unexpected queue length %d and dropped count %d", tsp.QueueLen(), tsp.Dropped())
+	}
+	frames := tsp.batch(nil)
+	if len(frames) != 2 || string(frames[0].Data) != "b" || string(frames[1].Data) != "c" {
+		t.Fatalf("unexpected frames %v", frames)
+	}
+}
+
+func TestOverflowDropOldestKeepsClose(t *testing.T) {
+	tsp := NewTransport(TransportOptions{QueueSize: 2, Overflow: OverflowDropOldest, QueueTimeout: 10 * time.Millisecond})
+	tsp.Send(packet.Packet{PacketType: packet.Message}, []byte("forced"), true)
+	send(tsp, "a", false)
+
+	// the forced frame is the oldest one, so the new frame waits instead.
+	if send(tsp, "b", false) {
+		t.Fatal("packet was queued")
+	}
+	frames := tsp.batch(nil)
+	if len(frames) != 2 || string(frames[0].Data) != "forced" || string(frames[1].Data) != "a" {
+		t.Fatalf("unexpected frames %v", frames)
+	}
+
+	tsp.Send(packet.Packet{PacketType: packet.Close}, nil, false)
+	send(tsp, "a", false)
+	if send(tsp, "b", false) {
+		t.Fatal("packet was queued")
+	}
+	if frame, _ := tsp.next(); frame.Packet.PacketType != packet.Close {
+		t.Fatalf("expected the close frame, got %v", frame)
+	}
+
+	// once the protected frames are sent, the oldest frame is dropped again.
+	send(tsp, "c", false)
+	if !send(tsp, "d", false) {
+		t.Fatal("d got dropped")
+	}
+	frames = tsp.batch(nil)
+	if len(frames) != 2 || string(frames[0].Data) != "c" || string(frames[1].Data) != "d" {
+		t.Fatalf("unexpected frames %v", frames)
+	}
+}
+
+func TestOverflowDropVolatile(t *testing.T) {
+	tsp := NewTransport(TransportOptions{QueueSize: 1, Overflow: OverflowDropVolatile, QueueTimeout: 10 * time.Millisecond})
+	send(tsp, "a", false)
+
+	if send(tsp, "b", true) {
+		t.Fatal("volatile packet was queued")
+	}
+	if tsp.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped packet, got %d", tsp.Dropped())
+	}
+
+	// other packets wait for the queue timeout.
+	start := time.Now()
+	if send(tsp, "c", false) {
+		t.Fatal("packet was queued")
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("send didn't block")
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	tsp := NewTransport(TransportOptions{QueueSize: 1})
+	send(tsp, "a", false)
+
+	sent := make(chan bool)
+	go func() {
+		sent <- send(tsp, "b", true)
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("send didn't block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	tsp.next()
+	if !<-sent {
+		t.Fatal("packet got dropped")
+	}
+}
+
+func TestOverflowDisconnect(t *testing.T) {
+	tsp := NewTransport(TransportOptions{QueueSize: 1, Overflow: OverflowDisconnect})
+	send(tsp, "a", false)
+
+	if send(tsp, "b", false) {
+		t.Fatal("packet was queued")
+	}
+
+	select {
+	case <-tsp.tspModerator:
+	case <-time.After(time.Second):
+		t.Fatal("transport wasn't closed")
+	}
+}
+
+func TestPollingDrain(t *testing.T) {
+	p := NewPolling(PollingOptions{TransportOptions: TransportOptions{SupportsBinary: true}}, "sid")
+	drained := make(chan int, 1)
+	p.OnDrain(func() {
+		drained <- p.QueueLen()
+	})
+	send(p, "a", false)
+	send(p, "b", false)
+
+	r := httptest.NewRequest("GET", "/engine.io/?EIO=3&transport=polling", nil)
+	w := httptest.NewRecorder()
+	p.HandleRequest(r, w)
+
+	if body := w.Body.String(); body != "2:4a2:4b" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	select {
+	case n := <-drained:
+		if n != 0 {
+			t.Fatalf("drained with %d queued packets", n)
+		}
+	default:
+		t.Fatal("drain wasn't called")
+	}
+}
@@ -1,20 +1,35 @@
 package transport
 
 import (
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
 	"github.com/adrianmxb/goseio/pkg/eio/parser"
 	"github.com/gorilla/websocket"
 	"io"
+	"net"
 	"sync"
 	"time"
 )
 
+// DefaultPerMessageDeflateThreshold is the minimal message size in bytes that gets compressed.
+const DefaultPerMessageDeflateThreshold = 1024
+
+type PerMessageDeflateOptions struct {
+	// Threshold is the minimal message size in bytes that gets compressed, defaults to
+	// DefaultPerMessageDeflateThreshold.
+	Threshold int
+	// Level is the compress/flate compression level, 0 keeps the default of gorilla/websocket.
+	Level int
+}
+
 type WSOptions struct {
 	TransportOptions
+	PerMessageDeflate PerMessageDeflateOptions
 }
 
 type Websocket struct {
 	*Transport
 	connection  *websocket.Conn
+	threshold   int
 	readerMutex sync.Mutex
 	writerMutex sync.Mutex
 }
@@ -23,11 +38,18 @@ func NewWebsocket(opt WSOptions, sid string, con *websocket.Conn) *Websocket {
 	ws := &Websocket{
 		Transport:  NewTransport(opt.TransportOptions),
 		connection: con,
+		threshold:  opt.PerMessageDeflate.Threshold,
+	}
+	if ws.threshold == 0 {
+		ws.threshold = DefaultPerMessageDeflateThreshold
+	}
+	if opt.PerMessageDeflate.Level != 0 {
+		con.SetCompressionLevel(opt.PerMessageDeflate.Level)
 	}
 
-	go ws.startReceiver()
-	go ws.startSender()
-	go ws.startModeratorBuddy()
+	ws.spawn(ws.startReceiver)
+	ws.spawn(ws.startSender)
+	ws.spawn(ws.startModeratorBuddy)
 
 	return ws
 }
@@ -37,8 +59,12 @@ func (ws *Websocket) GetName() string {
 }
 
 func (ws *Websocket) startModeratorBuddy() {
+	closing := ws.tspClosing
 	for {
 		select {
+		case <-closing:
+			closing = nil
+			ws.sendClose()
 		case <-ws.tspModerator:
 			//oh no! my mate died... i can't live without him. :(
 			ws.connection.Close()
@@ -56,7 +82,7 @@ func (ws *Websocket) startReceiver() {
 			_, _, err := ws.connection.NextReader()
 			ws.readerMutex.Unlock()
 			if err != nil {
-				ws.tspModSignal <- "close"
+				ws.fail(readErr(err))
 				return
 			}
 		default:
@@ -67,6 +93,7 @@ func (ws *Websocket) startReceiver() {
 		ws.readerMutex.Unlock()
 
 		if err != nil {
+			ws.setErr(readErr(err))
 			close(ws.recvPacket)
 			close(ws.recvData)
 			select {
@@ -84,47 +111,98 @@ func (ws *Websocket) startReceiver() {
 		}
 
 		//read from connection until NextReader throws, handle everything in err handler.
-		ws.recvPacket <- *pack
-		ws.recvData <- data
+		select {
+		case <-ws.tspModerator:
+			return
+		case ws.recvPacket <- *pack:
+			// Recv always reads the data of a packet.
+			ws.recvData <- data
+		}
 	}
 }
 
 func (ws *Websocket) startSender() {
-	var writer io.WriteCloser
-	var err error
 	for {
-		select {
-		case <-ws.tspModerator:
+		frame, ok := ws.next()
+		if !ok {
+			ws.flush(ErrClosed)
 			return
-		case pack := <-ws.sendPacket:
-			data := <-ws.sendData
-
-			ws.writerMutex.Lock()
-			if pack.IsBinary && ws.SupportsBinary {
-				writer, err = ws.connection.NextWriter(websocket.BinaryMessage)
-			} else {
-				writer, err = ws.connection.NextWriter(websocket.TextMessage)
-			}
-			ws.writerMutex.Unlock()
-
-			//couldn't get writer, connection got force killed or timed out (closing state?)
-			if err != nil {
-				select {
-				case <-ws.tspModerator:
-					return
-				case ws.tspModSignal <- "close":
-					return
-				}
-			}
-			err = parser.WriteHeader(writer, pack, ws.SupportsBinary)
-			wrappedWriter := parser.PrepareWriter(writer, pack.IsBinary, ws.SupportsBinary)
+		}
 
-			wrappedWriter.Write(data)
+		ws.flushing([]Frame{frame})
+		err := ws.writeFrame(frame)
+		frame.done(err)
 
-			wrappedWriter.Close()
-			writer.Close()
+		//couldn't write, connection got force killed or timed out (closing state?)
+		if err != nil {
+			ws.logger.Debug("write failed", "error", err)
+			ws.flush(ErrClosed)
+			ws.fail(err)
+			return
 		}
+		ws.drained()
+	}
+}
+
+// readErr maps the error of a failed read to the reason the transport closed.
+func readErr(err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrPingTimeout
+	}
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway,
+		websocket.CloseNoStatusReceived) {
+		return ErrClientClosed
+	}
+	return err
+}
+
+func (ws *Websocket) writeFrame(frame Frame) error {
+	if frame.Prepared == nil {
+		return ws.write(frame.Packet, frame.Data)
+	}
+
+	message, err := frame.Prepared.websocketMessage(ws.SupportsBinary)
+	if err != nil {
+		return err
+	}
+
+	defer ws.writerMutex.Unlock()
+	ws.writerMutex.Lock()
+	ws.connection.EnableWriteCompression(frame.Packet.Compress && len(frame.Data) >= ws.threshold)
+	return ws.connection.WritePreparedMessage(message)
+}
+
+func (ws *Websocket) write(pack packet.Packet, data []byte) error {
+	var writer io.WriteCloser
+	var err error
+
+	ws.writerMutex.Lock()
+	// only has an effect if permessage-deflate was negotiated.
+	ws.connection.EnableWriteCompression(pack.Compress && len(data) >= ws.threshold)
+	if pack.IsBinary && ws.SupportsBinary {
+		writer, err = ws.connection.NextWriter(websocket.BinaryMessage)
+	} else {
+		writer, err = ws.connection.NextWriter(websocket.TextMessage)
+	}
+	ws.writerMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := parser.WriteHeader(writer, pack, ws.SupportsBinary); err != nil {
+		writer.Close()
+		return err
+	}
+	wrappedWriter := parser.PrepareWriter(writer, pack.IsBinary, ws.SupportsBinary)
+	if _, err := wrappedWriter.Write(data); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := wrappedWriter.Close(); err != nil {
+		writer.Close()
+		return err
 	}
+	return writer.Close()
 }
 
 func (ws *Websocket) SetReadDeadline(t time.Time) error {
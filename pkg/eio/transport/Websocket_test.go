@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingConn struct {
+	net.Conn
+	read int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+// sendWebsocket sends a single message through a websocket transport and returns the number of bytes the
+// client received on the wire.
+func sendWebsocket(t *testing.T, opt PerMessageDeflateOptions, message string, compress bool) int64 {
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws := NewWebsocket(WSOptions{
+			TransportOptions:  TransportOptions{SupportsBinary: true},
+			PerMessageDeflate: opt,
+		}, "sid", conn)
+		ws.Send(packet.Packet{PacketType: packet.Message, Compress: compress}, []byte(message), false)
+	}))
+	defer srv.Close()
+
+	var counter *countingConn
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			counter = &countingConn{Conn: conn}
+			return counter, err
+		},
+	}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "4"+message {
+		t.Fatalf("unexpected message %q", data)
+	}
+	return atomic.LoadInt64(&counter.read)
+}
+
+func TestWebsocketPerMessageDeflate(t *testing.T) {
+	large := strings.Repeat("hello world ", 500)
+	cases := []struct {
+		name       string
+		opt        PerMessageDeflateOptions
+		message    string
+		compress   bool
+		compressed bool
+	}{
+		{"large", PerMessageDeflateOptions{}, large, true, true},
+		{"no compress flag", PerMessageDeflateOptions{}, large, false, false},
+		{"below threshold", PerMessageDeflateOptions{Threshold: 10000}, large, true, false},
+		{"level", PerMessageDeflateOptions{Level: 9}, large, true, true},
+	}
+
+	for _, c := range cases {
+		read := sendWebsocket(t, c.opt, c.message, c.compress)
+		if compressed := read < int64(len(c.message)); compressed != c.compressed {
+			t.Errorf("%s: expected compressed to be %v, %d bytes were read for a %d byte message",
+				c.name, c.compressed, read, len(c.message))
+		}
+	}
+}
+
+func TestWebsocketWait(t *testing.T) {
+	transports := make(chan *Websocket, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		transports <- NewWebsocket(WSOptions{}, "sid", conn)
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ws := <-transports
+
+	// nobody receives the message, the receiver has to give up once the transport closed.
+	conn.WriteMessage(websocket.TextMessage, []byte("4hello"))
+	time.Sleep(20 * time.Millisecond)
+	ws.ForceClose()
+
+	done := make(chan struct{})
+	go func() {
+		ws.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the goroutines of the transport didn't exit")
+	}
+	if ws.spawn(func() {}) {
+		t.Fatal("spawned a goroutine after Wait")
+	}
+}
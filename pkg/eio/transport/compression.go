@@ -0,0 +1,180 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressionThreshold is the minimal size of a polling response in bytes that gets compressed.
+const DefaultCompressionThreshold = 1024
+
+type HttpCompressionOptions struct {
+	// Threshold is the minimal response size in bytes that gets compressed, defaults to
+	// DefaultCompressionThreshold.
+	Threshold int
+	// Encodings lists the content encodings that may be used in order of preference,
+	// defaults to gzip and deflate. Every encoding must be registered with RegisterHttpCompressor.
+	Encodings []string
+}
+
+// ResetWriteCloser is a compressing writer which can be reused for another destination, like gzip.Writer.
+type ResetWriteCloser interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// HttpCompressor creates the compressing writer of a content encoding. Writers are pooled and reused.
+type HttpCompressor func(w io.Writer) (ResetWriteCloser, error)
+
+type compressorPool struct {
+	compressor HttpCompressor
+	pool       sync.Pool
+}
+
+var defaultEncodings = []string{"gzip", "deflate"}
+
+var compressorsLock sync.RWMutex
+var compressors = map[string]*compressorPool{
+	"gzip": {compressor: func(w io.Writer) (ResetWriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}},
+	// the http deflate encoding is the zlib format.
+	"deflate": {compressor: func(w io.Writer) (ResetWriteCloser, error) {
+		return zlib.NewWriter(w), nil
+	}},
+}
+
+// RegisterHttpCompressor makes a content encoding, e.g. "br", available for polling responses.
+// An already registered encoding is replaced.
+func RegisterHttpCompressor(encoding string, compressor HttpCompressor) {
+	defer compressorsLock.Unlock()
+	compressorsLock.Lock()
+	compressors[strings.ToLower(encoding)] = &compressorPool{compressor: compressor}
+}
+
+func getCompressorPool(encoding string) *compressorPool {
+	defer compressorsLock.RUnlock()
+	compressorsLock.RLock()
+	return compressors[encoding]
+}
+
+// compress writes the compressed body to dst, reusing a pooled writer.
+func (c *compressorPool) compress(dst io.Writer, body []byte) error {
+	var writer ResetWriteCloser
+	if pooled := c.pool.Get(); pooled != nil {
+		writer = pooled.(ResetWriteCloser)
+		writer.Reset(dst)
+	} else {
+		var err error
+		if writer, err = c.compressor(dst); err != nil {
+			return err
+		}
+	}
+
+	// a writer that failed may be in a broken state, so it isn't reused.
+	if _, err := writer.Write(body); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	c.pool.Put(writer)
+	return nil
+}
+
+// negotiateEncoding picks the encoding out of preferred that the client accepts with the highest q-value.
+// Ties are resolved by the order of preferred. An empty string means no compression.
+func negotiateEncoding(acceptEncoding string, preferred []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := make(map[string]float64)
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(entry, ";")
+		encoding := strings.ToLower(strings.TrimSpace(params[0]))
+		if encoding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		accepted[encoding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range preferred {
+		q, ok := accepted[encoding]
+		if !ok {
+			if q, ok = accepted["*"]; !ok {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// writeBody writes a polling response body, compressed if one of its packets allows it, the client supports it
+// and the body is large enough.
+func (p *Polling) writeBody(r *http.Request, w http.ResponseWriter, body []byte, compress bool) error {
+	if !p.PollingOptions.HttpCompression {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, err := w.Write(body)
+		return err
+	}
+
+	opt := p.PollingOptions.HttpCompressionOptions
+	threshold := opt.Threshold
+	if threshold == 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	preferred := opt.Encodings
+	if preferred == nil {
+		preferred = defaultEncodings
+	}
+
+	// caches must not serve a compressed response to clients not supporting it.
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	var pool *compressorPool
+	encoding := ""
+	if compress && len(body) >= threshold {
+		encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"), preferred)
+		pool = getCompressorPool(encoding)
+	}
+	if pool == nil {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, err := w.Write(body)
+		return err
+	}
+
+	buf := payloadBufPool.Get().(*bytes.Buffer)
+	defer payloadBufPool.Put(buf)
+	buf.Reset()
+
+	if err := pool.compress(buf, body); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
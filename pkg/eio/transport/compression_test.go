@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	preferred := []string{"gzip", "deflate"}
+	cases := map[string]string{
+		"":                             "",
+		"identity":                     "",
+		"gzip, deflate, br":            "gzip",
+		"deflate":                      "deflate",
+		"gzip;q=0.5, deflate":          "deflate",
+		"GZIP; Q=0.8, deflate;q=0.7":   "gzip",
+		"gzip;q=0, deflate;q=0":        "",
+		"*":                            "gzip",
+		"*;q=0.5, gzip;q=0":            "deflate",
+		"br;q=1.0, deflate;q=0.2, foo": "deflate",
+	}
+	for header, expected := range cases {
+		if encoding := negotiateEncoding(header, preferred); encoding != expected {
+			t.Errorf("%q: expected %q, got %q", header, expected, encoding)
+		}
+	}
+}
+
+func pollCompressed(t *testing.T, opt HttpCompressionOptions, message string, acceptEncoding string) *httptest.ResponseRecorder {
+	p := NewPolling(PollingOptions{
+		TransportOptions:       TransportOptions{SupportsBinary: true},
+		HttpCompression:        true,
+		HttpCompressionOptions: opt,
+	}, "sid")
+	p.Send(packet.Packet{PacketType: packet.Message, Compress: true}, []byte(message), false)
+
+	r := httptest.NewRequest("GET", "/engine.io/?EIO=3&transport=polling", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	w := httptest.NewRecorder()
+	p.HandleRequest(r, w)
+
+	if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Fatalf("expected Vary header, got %q", vary)
+	}
+	return w
+}
+
+func TestPollCompression(t *testing.T) {
+	message := strings.Repeat("hello world ", 200)
+	expected := "2401:4" + message
+
+	readers := map[string]func(r io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		"deflate": func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+	}
+
+	for encoding, newReader := range readers {
+		// run twice, the second response uses a pooled writer.
+		for i := 0; i < 2; i++ {
+			w := pollCompressed(t, HttpCompressionOptions{}, message, encoding+", identity")
+			if ce := w.Header().Get("Content-Encoding"); ce != encoding {
+				t.Fatalf("expected %s encoding, got %q", encoding, ce)
+			}
+			reader, err := newReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(reader)
+			if string(body) != expected {
+				t.Fatalf("unexpected body %q", body)
+			}
+		}
+	}
+
+	w := pollCompressed(t, HttpCompressionOptions{}, "short", "gzip")
+	if ce := w.Header().Get("Content-Encoding"); ce != "" || w.Body.String() != "6:4short" {
+		t.Fatalf("expected uncompressed response below threshold, got %q %q", ce, w.Body.String())
+	}
+
+	w = pollCompressed(t, HttpCompressionOptions{Threshold: 1}, "short", "gzip")
+	if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("expected configured threshold to be used, got %q", ce)
+	}
+}
+
+func TestRegisterHttpCompressor(t *testing.T) {
+	RegisterHttpCompressor("x-flate", func(w io.Writer) (ResetWriteCloser, error) {
+		return flate.NewWriter(w, flate.BestSpeed)
+	})
+
+	message := strings.Repeat("a", 2000)
+	w := pollCompressed(t, HttpCompressionOptions{Encodings: []string{"x-flate", "gzip"}}, message, "gzip, x-flate")
+	if ce := w.Header().Get("Content-Encoding"); ce != "x-flate" {
+		t.Fatalf("expected registered encoding, got %q", ce)
+	}
+	body, _ := ioutil.ReadAll(flate.NewReader(bytes.NewReader(w.Body.Bytes())))
+	if string(body) != "2001:4"+message {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestCompressorPoolFailure(t *testing.T) {
+	c := &compressorPool{compressor: func(w io.Writer) (ResetWriteCloser, error) {
+		return flate.NewWriter(w, flate.BestSpeed)
+	}}
+	if err := c.compress(failingWriter{}, []byte(strings.Repeat("a", 2000))); err == nil {
+		t.Fatal("expected the error of the destination")
+	}
+	// the broken writer isn't reused.
+	if writer := c.pool.Get(); writer != nil {
+		t.Fatalf("failed writer was pooled: %T", writer)
+	}
+}
@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"bytes"
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"github.com/adrianmxb/goseio/pkg/eio/parser"
+	"github.com/gorilla/websocket"
+	"io"
+	"sync"
+)
+
+// Prepared is a packet that gets encoded once for every kind of transport instead of once per client,
+// e.g. to broadcast it. It must not be changed once it was sent.
+type Prepared struct {
+	Packet packet.Packet
+	Data   []byte
+
+	// the encodings of websocketVariant and pollingVariant, see encode.
+	once    [4]sync.Once
+	encoded [4][]byte
+	err     [4]error
+
+	// messages are the websocket messages for clients without and with binary support, they frame and compress
+	// the encoding once per compression setting.
+	messagesOnce [2]sync.Once
+	messages     [2]*websocket.PreparedMessage
+	messagesErr  [2]error
+}
+
+func NewPrepared(pack packet.Packet, data []byte) *Prepared {
+	return &Prepared{Packet: pack, Data: data}
+}
+
+const (
+	websocketVariant = 0
+	pollingVariant   = 2
+)
+
+// encode returns the encoding of transport (websocketVariant or pollingVariant) for clients with or without
+// binary support.
+func (p *Prepared) encode(transport int, supportsBinary bool) ([]byte, error) {
+	variant := transport
+	if supportsBinary {
+		variant++
+	}
+
+	p.once[variant].Do(func() {
+		var buf bytes.Buffer
+		if transport == pollingVariant {
+			p.err[variant] = encodePayloadPacket(&buf, p.Packet, p.Data, supportsBinary)
+		} else {
+			p.err[variant] = encodeMessage(&buf, p.Packet, p.Data, supportsBinary)
+		}
+		p.encoded[variant] = buf.Bytes()
+	})
+	return p.encoded[variant], p.err[variant]
+}
+
+// websocketMessage returns the websocket message of the packet for clients with or without binary support.
+func (p *Prepared) websocketMessage(supportsBinary bool) (*websocket.PreparedMessage, error) {
+	variant := 0
+	if supportsBinary {
+		variant = 1
+	}
+
+	p.messagesOnce[variant].Do(func() {
+		data, err := p.encode(websocketVariant, supportsBinary)
+		if err != nil {
+			p.messagesErr[variant] = err
+			return
+		}
+		messageType := websocket.TextMessage
+		if p.Packet.IsBinary && supportsBinary {
+			messageType = websocket.BinaryMessage
+		}
+		p.messages[variant], p.messagesErr[variant] = websocket.NewPreparedMessage(messageType, data)
+	})
+	return p.messages[variant], p.messagesErr[variant]
+}
+
+// encodeMessage writes the websocket message of a packet.
+func encodeMessage(w io.Writer, pack packet.Packet, data []byte, supportsBinary bool) error {
+	if err := parser.WriteHeader(w, pack, supportsBinary); err != nil {
+		return err
+	}
+	writer := parser.PrepareWriter(w, pack.IsBinary, supportsBinary)
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// encodePayloadPacket writes a packet of a polling payload, prefixed by its length.
+func encodePayloadPacket(w io.Writer, pack packet.Packet, data []byte, supportsBinary bool) error {
+	if _, err := parser.EncodePayloadLength(w, pack, data, supportsBinary); err != nil {
+		return err
+	}
+	return encodeMessage(w, pack, data, supportsBinary)
+}
@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrepared(t *testing.T) {
+	text := packet.Packet{PacketType: packet.Message}
+	binary := packet.Packet{PacketType: packet.Message, IsBinary: true}
+	cases := []struct {
+		name           string
+		pack           packet.Packet
+		data           string
+		transport      int
+		supportsBinary bool
+		expected       string
+	}{
+		{"websocket text", text, "hé", websocketVariant, true, "4hé"},
+		{"websocket binary", binary, "\x01\x02", websocketVariant, true, "\x04\x01\x02"},
+		{"websocket base64", binary, "\x01\x02", websocketVariant, false, "b4AQI="},
+		{"polling text", text, "hé", pollingVariant, false, "3:4hé"},
+		{"polling binary", binary, "\x01\x02", pollingVariant, true, "\x01\x03\xff\x04\x01\x02"},
+		{"polling base64", binary, "\x01\x02", pollingVariant, false, "6:b4AQI="},
+	}
+
+	for _, c := range cases {
+		prepared := NewPrepared(c.pack, []byte(c.data))
+		encoded, err := prepared.encode(c.transport, c.supportsBinary)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if string(encoded) != c.expected {
+			t.Fatalf("%s: expected %q, got %q", c.name, c.expected, encoded)
+		}
+
+		// the encoding of a frame without a prepared one is the same.
+		var buf bytes.Buffer
+		if c.transport == pollingVariant {
+			err = encodePayloadPacket(&buf, c.pack, []byte(c.data), c.supportsBinary)
+		} else {
+			err = encodeMessage(&buf, c.pack, []byte(c.data), c.supportsBinary)
+		}
+		if err != nil || buf.String() != c.expected {
+			t.Fatalf("%s: expected %q, got %q, %v", c.name, c.expected, buf.String(), err)
+		}
+	}
+}
+
+// discardConn is a connection that discards everything written and blocks reads until it is closed.
+type discardConn struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *discardConn) Read(b []byte) (int, error) {
+	<-c.closed
+	return 0, io.EOF
+}
+
+func (c *discardConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c *discardConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+func (c *discardConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *discardConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *discardConn) SetDeadline(t time.Time) error      { return nil }
+func (c *discardConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *discardConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type hijacker struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+// newDiscardWebsocket returns a websocket transport writing to a discardConn, with permessage-deflate.
+func newDiscardWebsocket(b *testing.B) *Websocket {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-Websocket-Version", "13")
+	r.Header.Set("Sec-Websocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Sec-Websocket-Extensions", "permessage-deflate")
+
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	conn, err := upgrader.Upgrade(hijacker{httptest.NewRecorder(), &discardConn{closed: make(chan struct{})}}, r, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return NewWebsocket(WSOptions{TransportOptions: TransportOptions{SupportsBinary: true}}, "sid", conn)
+}
+
+// BenchmarkBroadcast writes a message to 10k websocket clients, once encoding and compressing it for every
+// client and once sharing a Prepared frame.
+func BenchmarkBroadcast(b *testing.B) {
+	const recipients = 10000
+	data := []byte(strings.Repeat("hello world ", 500))
+	ws := newDiscardWebsocket(b)
+	defer ws.ForceClose()
+
+	for _, compress := range []bool{false, true} {
+		pack := packet.Packet{PacketType: packet.Message, Compress: compress}
+		name := "uncompressed"
+		if compress {
+			name = "compressed"
+		}
+
+		b.Run(name+"/frames", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for r := 0; r < recipients; r++ {
+					if err := ws.writeFrame(Frame{Packet: pack, Data: data}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(name+"/prepared", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				prepared := NewPrepared(pack, data)
+				for r := 0; r < recipients; r++ {
+					if err := ws.writeFrame(Frame{Packet: pack, Data: data, Prepared: prepared}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
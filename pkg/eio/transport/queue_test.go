@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/adrianmxb/goseio/pkg/eio/packet"
+	"github.com/adrianmxb/goseio/pkg/eio/parser"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	hammerSenders  = 16
+	hammerMessages = 300
+)
+
+// hammerFrame returns the i-th frame of sender g. Pings and messages alternate and their data names the
+// packet type, so frames mixed up between senders get noticed.
+func hammerFrame(g, i int) Frame {
+	if i%2 == 0 {
+		return Frame{Packet: packet.Packet{PacketType: packet.Ping}, Data: []byte(fmt.Sprintf("ping-%d-%d", g, i))}
+	}
+	return Frame{Packet: packet.Packet{PacketType: packet.Message}, Data: []byte(fmt.Sprintf("message-%d-%d", g, i))}
+}
+
+func checkHammerFrame(t *testing.T, pack packet.Packet, data []byte, last map[int]int) {
+	var g, i int
+	var kind string
+	if _, err := fmt.Sscanf(strings.Replace(string(data), "-", " ", -1), "%s %d %d", &kind, &g, &i); err != nil {
+		t.Errorf("unexpected data %q", data)
+		return
+	}
+	if (kind == "ping") != (pack.PacketType == packet.Ping) {
+		t.Errorf("packet type %d doesn't belong to %q", pack.PacketType, data)
+	}
+	if prev, ok := last[g]; ok && prev >= i {
+		t.Errorf("%q arrived after message %d of the same sender", data, prev)
+	}
+	last[g] = i
+}
+
+type hammerResults struct {
+	lock    sync.Mutex
+	results map[error]int
+}
+
+func (h *hammerResults) done(err error) {
+	defer h.lock.Unlock()
+	h.lock.Lock()
+	h.results[err]++
+}
+
+func (h *hammerResults) get(err error) int {
+	defer h.lock.Unlock()
+	h.lock.Lock()
+	return h.results[err]
+}
+
+// hammer sends from many goroutines at once, the results count the errors the frames got completed with.
+func hammer(tsp *Transport) *hammerResults {
+	h := &hammerResults{results: make(map[error]int)}
+
+	var wg sync.WaitGroup
+	for g := 0; g < hammerSenders; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < hammerMessages; i++ {
+				frame := hammerFrame(g, i)
+				frame.Done = h.done
+				tsp.SendFrame(frame, false)
+			}
+		}(g)
+	}
+	wg.Wait()
+	return h
+}
+
+func TestSendHammer(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropOldest} {
+		tsp := NewTransport(TransportOptions{QueueSize: 4, Overflow: policy})
+
+		var received int64
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			last := make(map[int]int)
+			for {
+				frame, ok := tsp.next()
+				if !ok {
+					return
+				}
+				checkHammerFrame(t, frame.Packet, frame.Data, last)
+				atomic.AddInt64(&received, 1)
+				frame.done(nil)
+			}
+		}()
+
+		h := hammer(tsp)
+		tsp.tspModSignal <- "close"
+		<-done
+		tsp.flush(ErrClosed)
+
+		total := hammerSenders * hammerMessages
+		sent, dropped, closed := h.get(nil), h.get(ErrDropped), h.get(ErrClosed)
+		if sent+dropped+closed != total {
+			t.Fatalf("policy %d: %d of %d frames completed", policy, sent+dropped+closed, total)
+		}
+		if sent != int(atomic.LoadInt64(&received)) {
+			t.Fatalf("policy %d: %d frames were received, but %d completed", policy, received, sent)
+		}
+		if uint64(dropped) != tsp.Dropped() {
+			t.Fatalf("policy %d: %d frames were dropped, but counted %d", policy, dropped, tsp.Dropped())
+		}
+		if policy == OverflowBlock && dropped != 0 {
+			t.Fatalf("%d frames got dropped", dropped)
+		}
+	}
+}
+
+func TestPollingHammer(t *testing.T) {
+	p := NewPolling(PollingOptions{TransportOptions: TransportOptions{SupportsBinary: true, QueueSize: 8}}, "sid")
+
+	total := int64(hammerSenders * hammerMessages)
+	var received int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		last := make(map[int]int)
+		for atomic.LoadInt64(&received) < total {
+			r := httptest.NewRequest("GET", "/engine.io/?EIO=3&transport=polling", nil)
+			w := httptest.NewRecorder()
+			p.HandleRequest(r, w)
+
+			body := w.Body.Bytes()
+			readers, err := parser.DecodePayload(bytes.NewReader(body), len(body))
+			if err != nil {
+				t.Errorf("invalid payload %q: %v", body, err)
+				return
+			}
+			for _, reader := range readers {
+				pack, data, err := parser.AnalyzeReader(reader)
+				if err != nil {
+					t.Errorf("invalid packet: %v", err)
+					return
+				}
+				checkHammerFrame(t, *pack, data, last)
+				atomic.AddInt64(&received, 1)
+			}
+		}
+	}()
+
+	h := hammer(p.Transport)
+	<-done
+	if sent := int64(h.get(nil)); sent != total || received != total {
+		t.Fatalf("received %d and completed %d of %d frames", received, sent, total)
+	}
+}
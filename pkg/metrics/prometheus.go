@@ -0,0 +1,324 @@
+// Package metrics implements the eio and sio metrics hooks in the Prometheus text exposition format.
+//
+//	p := metrics.NewPrometheus()
+//	srv, _ := sio.NewServer(sio.ServerOptions{Metrics: p})
+//	p.Register(srv)
+//	http.Handle("/metrics", p)
+package metrics
+
+import (
+	"bufio"
+	"github.com/adrianmxb/goseio/pkg/eio"
+	"github.com/adrianmxb/goseio/pkg/sio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of the latency histograms in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+type family struct {
+	help   string
+	typ    metricType
+	labels []string
+	series map[string]*series
+}
+
+type series struct {
+	labels  []string
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+// Prometheus counts the events of eio and sio servers and writes them in the Prometheus text format.
+// Gauges like the number of connected clients are computed from the registered servers on every scrape.
+type Prometheus struct {
+	lock     sync.Mutex
+	buckets  []float64
+	families map[string]*family
+
+	serversLock sync.RWMutex
+	engines     []*eio.Server
+	servers     []*sio.Server
+}
+
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		buckets:  DefaultBuckets,
+		families: make(map[string]*family),
+	}
+
+	p.define("goseio_engine_handshakes_total", counterType, "Handshakes of new clients.", "transport")
+	p.define("goseio_engine_upgrades_total", counterType, "Successful transport upgrades.", "from", "to")
+	p.define("goseio_engine_upgrade_failures_total", counterType, "Aborted transport upgrades.", "transport")
+	p.define("goseio_engine_closed_total", counterType, "Closed clients.", "transport")
+	p.define("goseio_engine_received_packets_total", counterType, "Packets received from clients.", "transport")
+	p.define("goseio_engine_received_bytes_total", counterType, "Payload bytes received from clients.", "transport")
+	p.define("goseio_engine_sent_packets_total", counterType, "Messages queued for clients.", "transport")
+	p.define("goseio_engine_sent_bytes_total", counterType, "Payload bytes queued for clients.", "transport")
+	p.define("goseio_engine_poll_request_duration_seconds", histogramType, "Duration of polling requests.",
+		"method")
+	p.define("goseio_engine_clients", gaugeType, "Connected clients.", "transport")
+	p.define("goseio_engine_send_queue_packets", gaugeType, "Packets waiting in the send queues of all clients.")
+	p.define("goseio_engine_send_queue_max_packets", gaugeType, "Packets waiting in the longest send queue.")
+
+	p.define("goseio_socket_connects_total", counterType, "Sockets connected to a namespace.", "namespace")
+	p.define("goseio_socket_disconnects_total", counterType, "Sockets disconnected from a namespace.",
+		"namespace", "reason")
+	p.define("goseio_socket_ack_duration_seconds", histogramType, "Time until the client acknowledged an event.",
+		"namespace")
+	p.define("goseio_socket_sockets", gaugeType, "Sockets connected to a namespace on this node.", "namespace")
+	p.define("goseio_socket_rooms", gaugeType, "Rooms of a namespace on this node, without the rooms of the socket ids.",
+		"namespace")
+	return p
+}
+
+func (p *Prometheus) define(name string, typ metricType, help string, labels ...string) {
+	p.families[name] = &family{
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+}
+
+// RegisterEngine adds the gauges of an eio server, its events have to be passed by setting its Metrics field.
+func (p *Prometheus) RegisterEngine(srv *eio.Server) {
+	defer p.serversLock.Unlock()
+	p.serversLock.Lock()
+	p.engines = append(p.engines, srv)
+}
+
+// Register adds the gauges of a sio server and its eio server. Pass p as ServerOptions.Metrics to get its events.
+func (p *Prometheus) Register(srv *sio.Server) {
+	p.RegisterEngine(srv.Engine())
+
+	defer p.serversLock.Unlock()
+	p.serversLock.Lock()
+	p.servers = append(p.servers, srv)
+}
+
+// get returns the series of the family for the label values, p.lock has to be held.
+func (p *Prometheus) get(name string, values ...string) *series {
+	f := p.families[name]
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: values}
+		if f.typ == histogramType {
+			s.buckets = make([]uint64, len(p.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (p *Prometheus) add(name string, value float64, labels ...string) {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+	p.get(name, labels...).value += value
+}
+
+func (p *Prometheus) observe(name string, value float64, labels ...string) {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+	s := p.get(name, labels...)
+	s.value += value
+	s.count++
+	for i, bound := range p.buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (p *Prometheus) Handshake(transport string) {
+	p.add("goseio_engine_handshakes_total", 1, transport)
+}
+
+func (p *Prometheus) Upgrade(from string, to string) {
+	p.add("goseio_engine_upgrades_total", 1, from, to)
+}
+
+func (p *Prometheus) UpgradeFailed(transport string) {
+	p.add("goseio_engine_upgrade_failures_total", 1, transport)
+}
+
+func (p *Prometheus) Closed(transport string) {
+	p.add("goseio_engine_closed_total", 1, transport)
+}
+
+func (p *Prometheus) Received(transport string, bytes int) {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+	p.get("goseio_engine_received_packets_total", transport).value++
+	p.get("goseio_engine_received_bytes_total", transport).value += float64(bytes)
+}
+
+func (p *Prometheus) Sent(transport string, bytes int) {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+	p.get("goseio_engine_sent_packets_total", transport).value++
+	p.get("goseio_engine_sent_bytes_total", transport).value += float64(bytes)
+}
+
+func (p *Prometheus) PollRequest(method string, duration time.Duration) {
+	p.observe("goseio_engine_poll_request_duration_seconds", duration.Seconds(), method)
+}
+
+func (p *Prometheus) Connect(namespace string) {
+	p.add("goseio_socket_connects_total", 1, namespace)
+}
+
+func (p *Prometheus) Disconnect(namespace string, reason string) {
+	p.add("goseio_socket_disconnects_total", 1, namespace, reason)
+}
+
+func (p *Prometheus) Ack(namespace string, latency time.Duration) {
+	p.observe("goseio_socket_ack_duration_seconds", latency.Seconds(), namespace)
+}
+
+// collect computes the gauges from the registered servers.
+func (p *Prometheus) collect() {
+	p.serversLock.RLock()
+	engines := p.engines
+	servers := p.servers
+	p.serversLock.RUnlock()
+
+	clients := make(map[string]int)
+	queued, maxQueued := 0, 0
+	for _, engine := range engines {
+		for _, client := range engine.Clients() {
+			clients[client.TransportName()]++
+			n := client.QueueLen()
+			queued += n
+			if n > maxQueued {
+				maxQueued = n
+			}
+		}
+	}
+
+	sockets := make(map[string]int)
+	rooms := make(map[string]int)
+	for _, server := range servers {
+		for _, namespace := range server.Namespaces() {
+			sockets[namespace.Name()] += namespace.SocketCount()
+			if count, ok := namespace.RoomCount(); ok {
+				rooms[namespace.Name()] += count
+			}
+		}
+	}
+
+	defer p.lock.Unlock()
+	p.lock.Lock()
+	gauges := map[string]map[string]int{
+		"goseio_engine_clients": clients,
+		"goseio_socket_sockets": sockets,
+		"goseio_socket_rooms":   rooms,
+	}
+	for name, values := range gauges {
+		// forget series of transports or namespaces that are gone.
+		p.families[name].series = make(map[string]*series)
+		for label, value := range values {
+			p.get(name, label).value = float64(value)
+		}
+	}
+	p.get("goseio_engine_send_queue_packets").value = float64(queued)
+	p.get("goseio_engine_send_queue_max_packets").value = float64(maxQueued)
+}
+
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.Write(w)
+}
+
+// Write writes every metric in the text exposition format.
+func (p *Prometheus) Write(writer io.Writer) error {
+	p.collect()
+
+	w := bufio.NewWriter(writer)
+	p.lock.Lock()
+
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := p.families[name]
+		w.WriteString("# HELP " + name + " " + f.help + "\n")
+		w.WriteString("# TYPE " + name + " " + string(f.typ) + "\n")
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+			if f.typ != histogramType {
+				writeSample(w, name, f.labels, s.labels, "", "", s.value)
+				continue
+			}
+
+			for i, bound := range p.buckets {
+				writeSample(w, name+"_bucket", f.labels, s.labels, "le", formatFloat(bound), float64(s.buckets[i]))
+			}
+			writeSample(w, name+"_bucket", f.labels, s.labels, "le", "+Inf", float64(s.count))
+			writeSample(w, name+"_sum", f.labels, s.labels, "", "", s.value)
+			writeSample(w, name+"_count", f.labels, s.labels, "", "", float64(s.count))
+		}
+	}
+	p.lock.Unlock()
+	return w.Flush()
+}
+
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, extraLabel string,
+	extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraLabel + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/adrianmxb/goseio/pkg/sio"
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus()
+	srv, err := sio.NewServer(sio.ServerOptions{Metrics: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Register(srv)
+	connected := make(chan struct{})
+	srv.Of("/").OnConnect = func(socket *sio.Socket, next sio.NamespaceMiddleware) sio.NamespaceMiddleware {
+		socket.Join("lobby")
+		close(connected)
+		return nil
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/socket.io/?EIO=3&transport=websocket"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("socket didn't connect")
+	}
+
+	var out bytes.Buffer
+	if err := p.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE goseio_engine_handshakes_total counter",
+		`goseio_engine_handshakes_total{transport="websocket"} 1`,
+		`goseio_engine_clients{transport="websocket"} 1`,
+		`goseio_socket_connects_total{namespace="/"} 1`,
+		`goseio_socket_sockets{namespace="/"} 1`,
+		// lobby, the room of the socket id isn't counted.
+		`goseio_socket_rooms{namespace="/"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out.String())
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	p := NewPrometheus()
+	p.Ack("/", 30*time.Millisecond)
+	p.Ack("/", 2*time.Second)
+
+	var out bytes.Buffer
+	p.Write(&out)
+	for _, line := range []string{
+		`goseio_socket_ack_duration_seconds_bucket{namespace="/",le="0.025"} 0`,
+		`goseio_socket_ack_duration_seconds_bucket{namespace="/",le="0.05"} 1`,
+		`goseio_socket_ack_duration_seconds_bucket{namespace="/",le="2.5"} 2`,
+		`goseio_socket_ack_duration_seconds_bucket{namespace="/",le="+Inf"} 2`,
+		`goseio_socket_ack_duration_seconds_sum{namespace="/"} 2.03`,
+		`goseio_socket_ack_duration_seconds_count{namespace="/"} 2`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out.String())
+		}
+	}
+}
@@ -1,15 +1,24 @@
 package sio
 
+import (
+	"github.com/adrianmxb/goseio/pkg/eio"
+	"github.com/adrianmxb/goseio/pkg/sio/parser"
+	"sync"
+)
+
 type socketData struct {
 	joinedRooms map[string]bool
 }
 
 type Adapter struct {
 	namespace *Namespace
+	lock      sync.RWMutex
 	rooms     map[string][]string
 	sids      map[string]*socketData
 }
 
+// IAdapter keeps track of the rooms of a namespace and applies broadcast operations to its sockets.
+// Cluster adapters forward the broadcast operations to the other nodes unless FlagLocal is set.
 type IAdapter interface {
 	Add(id string, rooms ...string)
 	Del(id string, room string)
@@ -17,6 +26,22 @@ type IAdapter interface {
 
 	GetClientsIn(rooms ...string) []*Socket
 	GetRoomsOf(id string) []string
+
+	Broadcast(packet parser.Packet, opts BroadcastOptions) error
+	FetchSockets(opts BroadcastOptions) ([]*RemoteSocket, error)
+	AddSockets(opts BroadcastOptions, rooms []string)
+	DelSockets(opts BroadcastOptions, rooms []string)
+	DisconnectSockets(opts BroadcastOptions, close bool)
+	ServerSideEmit(event string, args []interface{}, ack ServerSideAckFunc) error
+
+	// Close releases the resources of the adapter once the server shuts down.
+	Close() error
+}
+
+// RoomLister is implemented by adapters that can list the rooms of the local sockets, see Namespace.RoomCount.
+// It isn't part of IAdapter, so adapters that don't implement it keep working.
+type RoomLister interface {
+	GetRooms() []string
 }
 
 func NewAdapter(namespace *Namespace) *Adapter {
@@ -28,11 +53,15 @@ func NewAdapter(namespace *Namespace) *Adapter {
 }
 
 func (a *Adapter) Add(id string, rooms ...string) {
+	defer a.lock.Unlock()
+	a.lock.Lock()
 	for _, room := range rooms {
 		if val, ok := a.sids[id]; !ok {
 			a.sids[id] = &socketData{
 				map[string]bool{room: true},
 			}
+		} else if val.joinedRooms[room] {
+			continue
 		} else {
 			val.joinedRooms[room] = true
 		}
@@ -45,6 +74,12 @@ func (a *Adapter) Add(id string, rooms ...string) {
 }
 
 func (a *Adapter) Del(id string, room string) {
+	defer a.lock.Unlock()
+	a.lock.Lock()
+	a.del(id, room)
+}
+
+func (a *Adapter) del(id string, room string) {
 	sd, ok := a.sids[id]
 	if ok {
 		delete(sd.joinedRooms, room)
@@ -71,45 +106,29 @@ func (a *Adapter) Del(id string, room string) {
 }
 
 func (a *Adapter) DelAll(id string) {
+	defer a.lock.Unlock()
+	a.lock.Lock()
 	sd, ok := a.sids[id]
 	if !ok {
 		return
 	}
 
 	for room, _ := range sd.joinedRooms {
-		a.Del(id, room)
+		a.del(id, room)
 	}
 }
 
 func (a *Adapter) GetClientsIn(rooms ...string) []*Socket {
-	var sids []*Socket
-	ids := make(map[string]bool)
-	if len(rooms) == 0 {
-		for id, _ := range a.sids {
-			sids = append(sids, a.namespace.connected[id])
-		}
-		return sids
-	}
-
-	for _, passedRoom := range rooms {
-		room, ok := a.rooms[passedRoom]
-		if !ok {
-			continue
-		}
-		for _, id := range room {
-			if _, ok := ids[id]; ok {
-				continue
-			}
-			if socket, ok := a.namespace.connected[id]; ok {
-				sids = append(sids, socket)
-				ids[id] = true
-			}
-		}
+	opts := BroadcastOptions{Rooms: make(map[string]struct{}, len(rooms))}
+	for _, room := range rooms {
+		opts.Rooms[room] = struct{}{}
 	}
-	return sids
+	return a.sockets(opts)
 }
 
 func (a *Adapter) GetRoomsOf(id string) []string {
+	defer a.lock.RUnlock()
+	a.lock.RLock()
 	sd, ok := a.sids[id]
 	if !ok {
 		return nil
@@ -124,3 +143,108 @@ func (a *Adapter) GetRoomsOf(id string) []string {
 
 	return keys
 }
+
+// GetRooms returns every room of the local sockets, including the rooms named after their ids.
+func (a *Adapter) GetRooms() []string {
+	defer a.lock.RUnlock()
+	a.lock.RLock()
+	rooms := make([]string, 0, len(a.rooms))
+	for room := range a.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// sockets returns the connected sockets matching opts.
+func (a *Adapter) sockets(opts BroadcastOptions) []*Socket {
+	defer a.lock.RUnlock()
+	a.lock.RLock()
+	defer a.namespace.lock.RUnlock()
+	a.namespace.lock.RLock()
+
+	except := make(map[string]bool)
+	for room := range opts.Except {
+		for _, id := range a.rooms[room] {
+			except[id] = true
+		}
+	}
+
+	var sockets []*Socket
+	if len(opts.Rooms) == 0 {
+		for id, socket := range a.namespace.connected {
+			if !except[id] {
+				sockets = append(sockets, socket)
+			}
+		}
+		return sockets
+	}
+
+	ids := make(map[string]bool)
+	for passedRoom := range opts.Rooms {
+		for _, id := range a.rooms[passedRoom] {
+			if ids[id] || except[id] {
+				continue
+			}
+			if socket, ok := a.namespace.connected[id]; ok {
+				sockets = append(sockets, socket)
+				ids[id] = true
+			}
+		}
+	}
+	return sockets
+}
+
+// Broadcast encodes the packet once and writes it to every matching socket, see eio.Broadcast.
+// It returns the error of the encoder, if any.
+func (a *Adapter) Broadcast(packet parser.Packet, opts BroadcastOptions) error {
+	data, isBinary, err := a.namespace.server.encoder.Encode(packet)
+	if err != nil {
+		return err
+	}
+
+	sockets := a.sockets(opts)
+	conns := make([]*eio.Socket, len(sockets))
+	for i, socket := range sockets {
+		conns[i] = socket.client.conn
+	}
+	eio.Broadcast(conns, data, isBinary, sendOptions(opts.Flags, nil))
+	return nil
+}
+
+func (a *Adapter) FetchSockets(opts BroadcastOptions) ([]*RemoteSocket, error) {
+	sockets := a.sockets(opts)
+	remote := make([]*RemoteSocket, len(sockets))
+	for i, socket := range sockets {
+		remote[i] = newRemoteSocket(a.namespace, socket.id, a.GetRoomsOf(socket.id))
+	}
+	return remote, nil
+}
+
+func (a *Adapter) AddSockets(opts BroadcastOptions, rooms []string) {
+	for _, socket := range a.sockets(opts) {
+		socket.Join(rooms...)
+	}
+}
+
+func (a *Adapter) DelSockets(opts BroadcastOptions, rooms []string) {
+	for _, socket := range a.sockets(opts) {
+		for _, room := range rooms {
+			socket.Leave(room)
+		}
+	}
+}
+
+func (a *Adapter) DisconnectSockets(opts BroadcastOptions, close bool) {
+	for _, socket := range a.sockets(opts) {
+		socket.Disconnect(close)
+	}
+}
+
+// ServerSideEmit is not supported without other nodes.
+func (a *Adapter) ServerSideEmit(event string, args []interface{}, ack ServerSideAckFunc) error {
+	return serverSideEmitErr
+}
+
+func (a *Adapter) Close() error {
+	return nil
+}
@@ -0,0 +1,157 @@
+package sio
+
+import (
+	"errors"
+	"github.com/adrianmxb/goseio/pkg/sio/parser"
+)
+
+var broadcastAckErr = errors.New("acknowledgements are not supported when broadcasting")
+
+// BroadcastOperator applies operations to a set of sockets of a namespace. All operations go through the
+// namespace's IAdapter, so they reach the sockets of other nodes as well when a cluster adapter is used.
+type BroadcastOperator struct {
+	namespace *Namespace
+	opts      BroadcastOptions
+}
+
+func newBroadcastOperator(namespace *Namespace) *BroadcastOperator {
+	return &BroadcastOperator{
+		namespace: namespace,
+		opts: BroadcastOptions{
+			Rooms:  make(map[string]struct{}),
+			Except: make(map[string]struct{}),
+			Flags:  FlagCompress,
+		},
+	}
+}
+
+func (b *BroadcastOperator) clone() *BroadcastOperator {
+	op := newBroadcastOperator(b.namespace)
+	op.opts.Flags = b.opts.Flags
+	for room := range b.opts.Rooms {
+		op.opts.Rooms[room] = struct{}{}
+	}
+	for room := range b.opts.Except {
+		op.opts.Except[room] = struct{}{}
+	}
+	return op
+}
+
+// To targets the sockets in the given rooms.
+func (b *BroadcastOperator) To(rooms ...string) *BroadcastOperator {
+	op := b.clone()
+	for _, room := range rooms {
+		op.opts.Rooms[room] = struct{}{}
+	}
+	return op
+}
+
+// In is an alias for To.
+func (b *BroadcastOperator) In(rooms ...string) *BroadcastOperator {
+	return b.To(rooms...)
+}
+
+// Except excludes the sockets in the given rooms.
+func (b *BroadcastOperator) Except(rooms ...string) *BroadcastOperator {
+	op := b.clone()
+	for _, room := range rooms {
+		op.opts.Except[room] = struct{}{}
+	}
+	return op
+}
+
+// Local restricts the operation to the sockets of the current node.
+func (b *BroadcastOperator) Local() *BroadcastOperator {
+	op := b.clone()
+	op.opts.Flags |= FlagLocal
+	return op
+}
+
+// Volatile marks the event as droppable.
+func (b *BroadcastOperator) Volatile() *BroadcastOperator {
+	op := b.clone()
+	op.opts.Flags |= FlagVolatile
+	return op
+}
+
+// Compress sets whether the event may be compressed, which is the default.
+func (b *BroadcastOperator) Compress(compress bool) *BroadcastOperator {
+	op := b.clone()
+	if compress {
+		op.opts.Flags |= FlagCompress
+	} else {
+		op.opts.Flags &^= FlagCompress
+	}
+	return op
+}
+
+// Emit sends an event to every targeted socket.
+func (b *BroadcastOperator) Emit(event string, args ...interface{}) error {
+	if reservedEvents[event] {
+		return reservedEvent
+	}
+	if len(args) > 0 {
+		if _, ok := args[len(args)-1].(AckFunc); ok {
+			return broadcastAckErr
+		}
+	}
+
+	return b.namespace.adapter.Broadcast(parser.Packet{
+		Type:      parser.Event,
+		Namespace: b.namespace.name,
+		Data:      append([]interface{}{event}, args...),
+	}, b.opts)
+}
+
+// FetchSockets returns the targeted sockets.
+func (b *BroadcastOperator) FetchSockets() ([]*RemoteSocket, error) {
+	return b.namespace.adapter.FetchSockets(b.opts)
+}
+
+// SocketsJoin makes the targeted sockets join the given rooms.
+func (b *BroadcastOperator) SocketsJoin(rooms ...string) {
+	b.namespace.adapter.AddSockets(b.opts, rooms)
+}
+
+// SocketsLeave makes the targeted sockets leave the given rooms.
+func (b *BroadcastOperator) SocketsLeave(rooms ...string) {
+	b.namespace.adapter.DelSockets(b.opts, rooms)
+}
+
+// DisconnectSockets disconnects the targeted sockets from the namespace. If close is set, the underlying
+// connections get closed as well.
+func (b *BroadcastOperator) DisconnectSockets(close bool) {
+	b.namespace.adapter.DisconnectSockets(b.opts, close)
+}
+
+// RemoteSocket describes a socket returned by FetchSockets, which might be connected to another node.
+type RemoteSocket struct {
+	Id    string   `json:"id"`
+	Rooms []string `json:"rooms"`
+
+	operator *BroadcastOperator
+}
+
+func newRemoteSocket(namespace *Namespace, id string, rooms []string) *RemoteSocket {
+	return &RemoteSocket{
+		Id:       id,
+		Rooms:    rooms,
+		operator: newBroadcastOperator(namespace).To(id),
+	}
+}
+
+func (r *RemoteSocket) Emit(event string, args ...interface{}) error {
+	return r.operator.Emit(event, args...)
+}
+
+func (r *RemoteSocket) Join(rooms ...string) {
+	r.operator.SocketsJoin(rooms...)
+}
+
+func (r *RemoteSocket) Leave(rooms ...string) {
+	r.operator.SocketsLeave(rooms...)
+}
+
+func (r *RemoteSocket) Disconnect(close bool) {
+	r.operator.DisconnectSockets(close)
+}
@@ -0,0 +1,92 @@
+package sio
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBroadcastOperator(t *testing.T) {
+	connected := make(chan *Socket, 3)
+	srv, ts := newTestServer(t, func(socket *Socket) {
+		socket.Join("game-42")
+		connected <- socket
+	})
+	defer ts.Close()
+
+	clients := []*testClient{dial(t, ts), dial(t, ts), dial(t, ts)}
+	for _, c := range clients {
+		defer c.close()
+	}
+	first := <-connected
+	<-connected
+	<-connected
+	first.Leave("game-42")
+
+	nsp := srv.Of("/")
+	nsp.In("game-42").SocketsJoin("lobby")
+	sockets, err := nsp.In("lobby").FetchSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sockets) != 2 {
+		t.Fatalf("expected 2 sockets in lobby, got %d", len(sockets))
+	}
+	for _, socket := range sockets {
+		rooms := socket.Rooms
+		sort.Strings(rooms)
+		expected := []string{socket.Id, "game-42", "lobby"}
+		sort.Strings(expected)
+		if !reflect.DeepEqual(rooms, expected) {
+			t.Fatalf("unexpected rooms %v", rooms)
+		}
+	}
+
+	nsp.In("lobby").SocketsLeave("game-42")
+	if sockets, _ := nsp.In("game-42").FetchSockets(); len(sockets) != 0 {
+		t.Fatalf("expected game-42 to be empty, got %d sockets", len(sockets))
+	}
+
+	if err := nsp.Except("lobby").Emit("news", "only first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nsp.Emit("news", "everyone"); err != nil {
+		t.Fatal(err)
+	}
+
+	received := 0
+	for _, c := range clients {
+		msg := c.readMessage()
+		if msg == `2["news","only first"]` {
+			received++
+			msg = c.readMessage()
+		}
+		if msg != `2["news","everyone"]` {
+			t.Fatalf("unexpected message %q", msg)
+		}
+	}
+	if received != 1 {
+		t.Fatalf("expected one socket outside of lobby, got %d", received)
+	}
+
+	nsp.In("lobby").DisconnectSockets(false)
+	if sockets, _ := nsp.FetchSockets(); len(sockets) != 1 || sockets[0].Id != first.Id() {
+		t.Fatalf("expected only the first socket to stay connected, got %v", sockets)
+	}
+}
+
+func TestBroadcastEncodeError(t *testing.T) {
+	srv, ts := newTestServer(t, func(socket *Socket) {})
+	defer ts.Close()
+
+	if err := srv.Of("/").Emit("news", make(chan int)); err == nil {
+		t.Fatal("expected the encoder error")
+	}
+
+	cluster := newTestCluster(t, 2, time.Second)
+	defer closeTestCluster(cluster)
+	if err := cluster[0].srv.Of("/").To("lobby").Emit("news", func() {}); err == nil {
+		t.Fatal("expected the encoder error of the cluster adapter")
+	}
+}
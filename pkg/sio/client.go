@@ -1,11 +1,18 @@
 package sio
 
-import "github.com/adrianmxb/goseio/pkg/eio"
+import (
+	"github.com/adrianmxb/goseio/pkg/eio"
+	"github.com/adrianmxb/goseio/pkg/sio/parser"
+	"strings"
+	"sync"
+)
 
 type Client struct {
 	server        *Server
 	conn          *eio.Socket
+	decoder       parser.Decoder
 	id            string
+	lock          sync.Mutex
 	sockets       map[string]*Socket
 	namespaces    map[string]*Socket
 	connectBuffer []string
@@ -15,6 +22,7 @@ func NewClient(server *Server, conn *eio.Socket) *Client {
 	client := &Client{
 		server:     server,
 		conn:       conn,
+		decoder:    server.parser.NewDecoder(),
 		id:         conn.Id,
 		sockets:    make(map[string]*Socket),
 		namespaces: make(map[string]*Socket),
@@ -23,28 +31,143 @@ func NewClient(server *Server, conn *eio.Socket) *Client {
 }
 
 func (c *Client) Connect(name string, query string) {
+	c.server.namespacesLock.RLock()
 	namespace, ok := c.server.namespaces[name]
+	c.server.namespacesLock.RUnlock()
 	if !ok {
+		c.server.logger.Debug("invalid namespace", "sid", c.id, "namespace", name)
+		c.packet(parser.Packet{
+			Type:      parser.Error,
+			Namespace: name,
+			Data:      "Invalid namespace",
+		}, FlagCompress)
 		return
 	}
 
+	c.lock.Lock()
 	//client not in main namespace yet... queue connection up.
 	if name != "/" {
 		if _, ok := c.namespaces["/"]; !ok {
 			c.connectBuffer = append(c.connectBuffer, name)
+			c.lock.Unlock()
 			return
 		}
 	}
+	c.lock.Unlock()
 
 	socket := namespace.Add(c, query)
 
+	c.lock.Lock()
 	c.sockets[socket.id] = socket
 	c.namespaces[namespace.name] = socket
 
+	var buffered []string
 	if namespace.name == "/" && len(c.connectBuffer) > 0 {
-		for _, name := range c.connectBuffer {
-			c.Connect(name, "")
-		}
+		buffered = c.connectBuffer
 		c.connectBuffer = nil
 	}
+	c.lock.Unlock()
+
+	for _, name := range buffered {
+		c.Connect(name, "")
+	}
+}
+
+// OnData decodes an incoming engine.io message and routes it to the socket of the packets namespace.
+func (c *Client) OnData(data []byte, isBinary bool) {
+	pack, err := c.decoder.Decode(data, isBinary)
+	if err != nil {
+		c.server.logger.Debug("invalid packet", "sid", c.id, "error", err)
+		return
+	}
+
+	if pack.Type == parser.Connect {
+		name, query := pack.Namespace, ""
+		if i := strings.IndexByte(name, '?'); i >= 0 {
+			name, query = name[:i], name[i+1:]
+		}
+		c.Connect(name, query)
+		return
+	}
+
+	c.lock.Lock()
+	socket, ok := c.namespaces[pack.Namespace]
+	c.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	socket.onPacket(pack)
+}
+
+func (c *Client) packet(pack parser.Packet, flags int) error {
+	return c.packetDone(pack, flags, nil)
+}
+
+// packetDone sends pack like packet, done gets called once it was flushed, see eio.SendOptions.Callback.
+func (c *Client) packetDone(pack parser.Packet, flags int, done func(err error)) error {
+	data, isBinary, err := c.server.encoder.Encode(pack)
+	if err != nil {
+		if done != nil {
+			done(err)
+		}
+		return err
+	}
+
+	return c.write(data, isBinary, flags, done)
+}
+
+func (c *Client) write(data []byte, isBinary bool, flags int, done func(err error)) error {
+	return c.conn.Send(data, isBinary, sendOptions(flags, done))
+}
+
+func sendOptions(flags int, done func(err error)) eio.SendOptions {
+	return eio.SendOptions{
+		Compress: flags&FlagCompress != 0,
+		Volatile: flags&FlagVolatile != 0,
+		Callback: done,
+	}
+}
+
+func (c *Client) close() {
+	c.conn.Close()
+}
+
+// onClose closes every socket of the client with reason.
+func (c *Client) onClose(reason string) {
+	c.lock.Lock()
+	sockets := make([]*Socket, 0, len(c.sockets))
+	for _, socket := range c.sockets {
+		sockets = append(sockets, socket)
+	}
+	c.lock.Unlock()
+
+	for _, socket := range sockets {
+		socket.onClose(reason)
+	}
+}
+
+// disconnect sends a disconnect packet to every namespace of the client before closing its sockets.
+func (c *Client) disconnect(reason string) {
+	c.lock.Lock()
+	sockets := make([]*Socket, 0, len(c.sockets))
+	for _, socket := range c.sockets {
+		sockets = append(sockets, socket)
+	}
+	c.lock.Unlock()
+
+	for _, socket := range sockets {
+		socket.packet(parser.Packet{
+			Type:      parser.Disconnect,
+			Namespace: socket.namespace.name,
+		})
+		socket.onClose(reason)
+	}
+}
+
+func (c *Client) remove(socket *Socket) {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+	delete(c.sockets, socket.id)
+	delete(c.namespaces, socket.namespace.name)
 }
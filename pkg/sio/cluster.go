@@ -0,0 +1,413 @@
+package sio
+
+import (
+	"errors"
+	"github.com/adrianmxb/goseio/pkg/eio"
+	"github.com/adrianmxb/goseio/pkg/sio/parser"
+	"github.com/adrianmxb/goseio/pkg/sio/parser/msgpack"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var serverSideEmitErr = errors.New("this adapter does not support serverSideEmit")
+var requestTimeoutErr = errors.New("timeout reached while waiting for the other nodes")
+var adapterClosedErr = errors.New("adapter closed")
+
+// ServerSideAckFunc receives the acknowledgements of every other node for a ServerSideEmit call.
+// err is set if not every node responded within the adapters request timeout.
+type ServerSideAckFunc func(responses [][]interface{}, err error)
+
+// ServerSideHandlerFunc handles an event sent by another node with ServerSideEmit.
+// ack is nil if the sending node didn't ask for an acknowledgement.
+type ServerSideHandlerFunc func(args []interface{}, ack AckFunc)
+
+// ClusterBus delivers messages between the nodes of a cluster, e.g. through redis pub/sub.
+type ClusterBus interface {
+	// Publish sends msg to the subscriber of the namespace on every other node.
+	Publish(namespace string, msg []byte) error
+	// Subscribe sets the function receiving the messages for the namespace published by other nodes,
+	// nil unsubscribes.
+	Subscribe(namespace string, fn func(msg []byte))
+	// ServerCount returns the number of nodes in the cluster, including this one.
+	ServerCount() int
+}
+
+type ClusterAdapterOptions struct {
+	Bus ClusterBus
+	// RequestTimeout limits how long requests wait for the responses of the other nodes, defaults to 5s.
+	RequestTimeout time.Duration
+}
+
+const (
+	clusterBroadcast = iota
+	clusterSocketsJoin
+	clusterSocketsLeave
+	clusterDisconnectSockets
+	clusterFetchSockets
+	clusterFetchSocketsResponse
+	clusterServerSideEmit
+	clusterServerSideEmitResponse
+)
+
+// clusterMessage is sent as json, its Packet is encoded with the msgpack parser, so binary arguments aren't
+// turned into strings.
+type clusterMessage struct {
+	Uid       string           `json:"uid"`
+	Type      int              `json:"type"`
+	RequestId string           `json:"requestId,omitempty"`
+	Packet    []byte           `json:"packet,omitempty"`
+	Opts      BroadcastOptions `json:"opts"`
+	Rooms     []string         `json:"rooms,omitempty"`
+	Close     bool             `json:"close,omitempty"`
+	Data      []interface{}    `json:"data,omitempty"`
+	Sockets   []*RemoteSocket  `json:"sockets,omitempty"`
+}
+
+type clusterRequest struct {
+	expected  int
+	responses [][]interface{}
+	sockets   []*RemoteSocket
+	err       error
+	done      chan struct{}
+}
+
+// ClusterAdapter keeps the rooms of the local sockets like Adapter and forwards every broadcast operation
+// to the other nodes through a ClusterBus.
+type ClusterAdapter struct {
+	*Adapter
+	uid       string
+	bus       ClusterBus
+	timeout   time.Duration
+	requestId uint64
+
+	requestsLock sync.Mutex
+	requests     map[string]*clusterRequest
+
+	// operations of other nodes on the local sockets are applied in the order they were received, but not on
+	// the goroutine of the bus, so a blocking broadcast doesn't delay the responses to this node's requests.
+	opsLock sync.Mutex
+	opsCond *sync.Cond
+	ops     []func()
+	closed  bool
+}
+
+func NewClusterAdapter(namespace *Namespace, opt ClusterAdapterOptions) *ClusterAdapter {
+	uid, _ := eio.GenerateID()
+	if opt.RequestTimeout == 0 {
+		opt.RequestTimeout = 5 * time.Second
+	}
+
+	a := &ClusterAdapter{
+		Adapter:  NewAdapter(namespace),
+		uid:      uid,
+		bus:      opt.Bus,
+		timeout:  opt.RequestTimeout,
+		requests: make(map[string]*clusterRequest),
+	}
+	a.opsCond = sync.NewCond(&a.opsLock)
+	go a.run()
+	a.bus.Subscribe(namespace.name, a.onMessage)
+	return a
+}
+
+// apply queues an operation of another node.
+func (a *ClusterAdapter) apply(op func()) {
+	defer a.opsLock.Unlock()
+	a.opsLock.Lock()
+	if !a.closed {
+		a.ops = append(a.ops, op)
+		a.opsCond.Signal()
+	}
+}
+
+func (a *ClusterAdapter) run() {
+	for {
+		a.opsLock.Lock()
+		for len(a.ops) == 0 && !a.closed {
+			a.opsCond.Wait()
+		}
+		if a.closed {
+			a.opsLock.Unlock()
+			return
+		}
+		op := a.ops[0]
+		a.ops[0] = nil
+		a.ops = a.ops[1:]
+		a.opsLock.Unlock()
+
+		op()
+	}
+}
+
+// publish sends msg to the other nodes. Failures are logged for the operations without a result and returned
+// to the others.
+func (a *ClusterAdapter) publish(msg *clusterMessage) error {
+	msg.Uid = a.uid
+	b, err := json.Marshal(msg)
+	if err != nil {
+		a.namespace.server.logger.Warn("invalid cluster message", "namespace", a.namespace.name, "error", err)
+		return err
+	}
+	if err := a.bus.Publish(a.namespace.name, b); err != nil {
+		a.namespace.server.logger.Warn("publish failed", "namespace", a.namespace.name, "error", err)
+		return err
+	}
+	return nil
+}
+
+// Broadcast sends the packet to the local sockets even if it couldn't be published, the error is returned
+// afterwards.
+func (a *ClusterAdapter) Broadcast(packet parser.Packet, opts BroadcastOptions) error {
+	var published error
+	if opts.Flags&FlagLocal == 0 {
+		b, _, err := (msgpack.Encoder{}).Encode(packet)
+		if err != nil {
+			return err
+		}
+		published = a.publish(&clusterMessage{Type: clusterBroadcast, Packet: b, Opts: opts})
+	}
+	if err := a.Adapter.Broadcast(packet, opts); err != nil {
+		return err
+	}
+	return published
+}
+
+func (a *ClusterAdapter) AddSockets(opts BroadcastOptions, rooms []string) {
+	if opts.Flags&FlagLocal == 0 {
+		a.publish(&clusterMessage{Type: clusterSocketsJoin, Opts: opts, Rooms: rooms})
+	}
+	a.Adapter.AddSockets(opts, rooms)
+}
+
+func (a *ClusterAdapter) DelSockets(opts BroadcastOptions, rooms []string) {
+	if opts.Flags&FlagLocal == 0 {
+		a.publish(&clusterMessage{Type: clusterSocketsLeave, Opts: opts, Rooms: rooms})
+	}
+	a.Adapter.DelSockets(opts, rooms)
+}
+
+func (a *ClusterAdapter) DisconnectSockets(opts BroadcastOptions, close bool) {
+	if opts.Flags&FlagLocal == 0 {
+		a.publish(&clusterMessage{Type: clusterDisconnectSockets, Opts: opts, Close: close})
+	}
+	a.Adapter.DisconnectSockets(opts, close)
+}
+
+func (a *ClusterAdapter) FetchSockets(opts BroadcastOptions) ([]*RemoteSocket, error) {
+	sockets, _ := a.Adapter.FetchSockets(opts)
+	if opts.Flags&FlagLocal != 0 {
+		return sockets, nil
+	}
+
+	req, id := a.newRequest()
+	if req == nil {
+		return sockets, nil
+	}
+	if err := a.publish(&clusterMessage{Type: clusterFetchSockets, RequestId: id, Opts: opts}); err != nil {
+		a.cancel(id)
+		return sockets, err
+	}
+
+	err := a.wait(req, id)
+	for _, socket := range req.sockets {
+		sockets = append(sockets, newRemoteSocket(a.namespace, socket.Id, socket.Rooms))
+	}
+	return sockets, err
+}
+
+// ServerSideEmit sends an event to the other nodes. If ack is set, it gets called with the responses of
+// every other node, or once the request timeout is reached.
+func (a *ClusterAdapter) ServerSideEmit(event string, args []interface{}, ack ServerSideAckFunc) error {
+	msg := &clusterMessage{
+		Type: clusterServerSideEmit,
+		Data: append([]interface{}{event}, args...),
+	}
+
+	if ack == nil {
+		return a.publish(msg)
+	}
+
+	req, id := a.newRequest()
+	if req == nil {
+		ack(nil, nil)
+		return nil
+	}
+	msg.RequestId = id
+	if err := a.publish(msg); err != nil {
+		a.cancel(id)
+		return err
+	}
+
+	go func() {
+		err := a.wait(req, id)
+		ack(req.responses, err)
+	}()
+	return nil
+}
+
+// newRequest registers a request waiting for a response of every other node, it returns nil if this
+// is the only node.
+func (a *ClusterAdapter) newRequest() (*clusterRequest, string) {
+	expected := a.bus.ServerCount() - 1
+	if expected <= 0 {
+		return nil, ""
+	}
+
+	id := a.uid + "-" + strconv.FormatUint(atomic.AddUint64(&a.requestId, 1), 10)
+	req := &clusterRequest{
+		expected: expected,
+		done:     make(chan struct{}),
+	}
+
+	a.requestsLock.Lock()
+	a.requests[id] = req
+	a.requestsLock.Unlock()
+	return req, id
+}
+
+func (a *ClusterAdapter) cancel(id string) {
+	defer a.requestsLock.Unlock()
+	a.requestsLock.Lock()
+	delete(a.requests, id)
+}
+
+func (a *ClusterAdapter) wait(req *clusterRequest, id string) error {
+	timer := time.NewTimer(a.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-req.done:
+		return req.err
+	case <-timer.C:
+		a.requestsLock.Lock()
+		defer a.requestsLock.Unlock()
+		delete(a.requests, id)
+
+		// the last response might have arrived just now.
+		select {
+		case <-req.done:
+			return req.err
+		default:
+			return requestTimeoutErr
+		}
+	}
+}
+
+// onResponse stores a response of another node, data is applied under the requests lock.
+func (a *ClusterAdapter) onResponse(id string, apply func(req *clusterRequest)) {
+	defer a.requestsLock.Unlock()
+	a.requestsLock.Lock()
+	req, ok := a.requests[id]
+	if !ok {
+		return
+	}
+
+	apply(req)
+	req.expected--
+	if req.expected == 0 {
+		delete(a.requests, id)
+		close(req.done)
+	}
+}
+
+// Close unsubscribes from the bus and fails the pending requests. The bus itself is shared by the namespaces
+// and closed by its owner.
+func (a *ClusterAdapter) Close() error {
+	a.bus.Subscribe(a.namespace.name, nil)
+
+	a.opsLock.Lock()
+	a.closed = true
+	a.ops = nil
+	a.opsCond.Broadcast()
+	a.opsLock.Unlock()
+
+	defer a.requestsLock.Unlock()
+	a.requestsLock.Lock()
+	for id, req := range a.requests {
+		req.err = adapterClosedErr
+		delete(a.requests, id)
+		close(req.done)
+	}
+	return nil
+}
+
+func (a *ClusterAdapter) onMessage(b []byte) {
+	msg := &clusterMessage{}
+	if err := json.Unmarshal(b, msg); err != nil || msg.Uid == a.uid {
+		return
+	}
+
+	// operations of other nodes only apply to the local sockets.
+	msg.Opts.Flags |= FlagLocal
+
+	// responses are handled right away, everything else runs off the goroutine of the bus.
+	switch msg.Type {
+	case clusterBroadcast:
+		packet, err := (msgpack.Decoder{}).Decode(msg.Packet, true)
+		if err != nil {
+			return
+		}
+		packet.Namespace = a.namespace.name
+		a.apply(func() {
+			a.Adapter.Broadcast(*packet, msg.Opts)
+		})
+	case clusterSocketsJoin:
+		a.apply(func() {
+			a.Adapter.AddSockets(msg.Opts, msg.Rooms)
+		})
+	case clusterSocketsLeave:
+		a.apply(func() {
+			a.Adapter.DelSockets(msg.Opts, msg.Rooms)
+		})
+	case clusterDisconnectSockets:
+		a.apply(func() {
+			a.Adapter.DisconnectSockets(msg.Opts, msg.Close)
+		})
+	case clusterFetchSockets:
+		a.apply(func() {
+			sockets, _ := a.Adapter.FetchSockets(msg.Opts)
+			a.publish(&clusterMessage{
+				Type:      clusterFetchSocketsResponse,
+				RequestId: msg.RequestId,
+				Sockets:   sockets,
+			})
+		})
+	case clusterFetchSocketsResponse:
+		a.onResponse(msg.RequestId, func(req *clusterRequest) {
+			req.sockets = append(req.sockets, msg.Sockets...)
+		})
+	case clusterServerSideEmit:
+		if len(msg.Data) == 0 {
+			return
+		}
+		event, ok := msg.Data[0].(string)
+		if !ok {
+			return
+		}
+
+		var ack AckFunc
+		if msg.RequestId != "" {
+			var once sync.Once
+			ack = func(args ...interface{}) {
+				once.Do(func() {
+					if args == nil {
+						args = []interface{}{}
+					}
+					a.publish(&clusterMessage{
+						Type:      clusterServerSideEmitResponse,
+						RequestId: msg.RequestId,
+						Data:      args,
+					})
+				})
+			}
+		}
+		// handlers may wait for other nodes, e.g. with FetchSockets.
+		go a.namespace.onServerSideEmit(event, msg.Data[1:], ack)
+	case clusterServerSideEmitResponse:
+		a.onResponse(msg.RequestId, func(req *clusterRequest) {
+			req.responses = append(req.responses, msg.Data)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package sio
+
+import (
+	"errors"
+	"sync"
+)
+
+var busClosedErr = errors.New("bus closed")
+
+// MemoryBroker connects multiple servers running in the same process, e.g. for tests.
+// Every server gets its own bus from NewBus.
+type MemoryBroker struct {
+	lock  sync.RWMutex
+	buses []*MemoryBus
+}
+
+// MemoryBus is the ClusterBus of a node of a MemoryBroker.
+type MemoryBus struct {
+	broker *MemoryBroker
+	lock   sync.RWMutex
+	subs   map[string]func(msg []byte)
+
+	// queue holds the messages published by the other nodes, it is unbounded so Publish never blocks, e.g.
+	// while a subscriber of another node publishes a response.
+	queueLock sync.Mutex
+	queueCond *sync.Cond
+	queue     []memoryMessage
+	closed    bool
+}
+
+type memoryMessage struct {
+	namespace string
+	data      []byte
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+// NewBus adds a node to the broker, Close removes it.
+func (b *MemoryBroker) NewBus() *MemoryBus {
+	bus := &MemoryBus{
+		broker: b,
+		subs:   make(map[string]func(msg []byte)),
+	}
+	bus.queueCond = sync.NewCond(&bus.queueLock)
+	go bus.deliver()
+
+	b.lock.Lock()
+	b.buses = append(b.buses, bus)
+	b.lock.Unlock()
+	return bus
+}
+
+// deliver passes the messages to the subscribers one after another, so they are received in the order they
+// were published.
+func (bus *MemoryBus) deliver() {
+	for {
+		msg, ok := bus.pop()
+		if !ok {
+			return
+		}
+
+		bus.lock.RLock()
+		fn := bus.subs[msg.namespace]
+		bus.lock.RUnlock()
+		if fn != nil {
+			fn(msg.data)
+		}
+	}
+}
+
+func (bus *MemoryBus) push(msg memoryMessage) {
+	defer bus.queueLock.Unlock()
+	bus.queueLock.Lock()
+	if !bus.closed {
+		bus.queue = append(bus.queue, msg)
+		bus.queueCond.Signal()
+	}
+}
+
+func (bus *MemoryBus) pop() (memoryMessage, bool) {
+	defer bus.queueLock.Unlock()
+	bus.queueLock.Lock()
+	for len(bus.queue) == 0 && !bus.closed {
+		bus.queueCond.Wait()
+	}
+	if bus.closed {
+		return memoryMessage{}, false
+	}
+	msg := bus.queue[0]
+	bus.queue[0] = memoryMessage{}
+	bus.queue = bus.queue[1:]
+	return msg, true
+}
+
+func (bus *MemoryBus) Publish(namespace string, msg []byte) error {
+	bus.queueLock.Lock()
+	closed := bus.closed
+	bus.queueLock.Unlock()
+	if closed {
+		return busClosedErr
+	}
+
+	bus.broker.lock.RLock()
+	buses := append([]*MemoryBus(nil), bus.broker.buses...)
+	bus.broker.lock.RUnlock()
+
+	for _, other := range buses {
+		if other != bus {
+			other.push(memoryMessage{namespace: namespace, data: msg})
+		}
+	}
+	return nil
+}
+
+func (bus *MemoryBus) Subscribe(namespace string, fn func(msg []byte)) {
+	defer bus.lock.Unlock()
+	bus.lock.Lock()
+	bus.subs[namespace] = fn
+}
+
+func (bus *MemoryBus) ServerCount() int {
+	defer bus.broker.lock.RUnlock()
+	bus.broker.lock.RLock()
+	return len(bus.broker.buses)
+}
+
+// Close removes the node from the broker and drops the messages that weren't delivered yet.
+func (bus *MemoryBus) Close() error {
+	bus.broker.lock.Lock()
+	for i, other := range bus.broker.buses {
+		if other == bus {
+			bus.broker.buses = append(bus.broker.buses[:i:i], bus.broker.buses[i+1:]...)
+			break
+		}
+	}
+	bus.broker.lock.Unlock()
+
+	defer bus.queueLock.Unlock()
+	bus.queueLock.Lock()
+	bus.closed = true
+	bus.queue = nil
+	bus.queueCond.Broadcast()
+	return nil
+}
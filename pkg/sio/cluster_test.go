@@ -0,0 +1,276 @@
+package sio
+
+import (
+	"bytes"
+	"github.com/adrianmxb/goseio/pkg/sio/parser/msgpack"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testNode struct {
+	srv       *Server
+	ts        *httptest.Server
+	bus       *MemoryBus
+	connected chan *Socket
+}
+
+// newTestCluster starts several servers in this process, connected through a MemoryBroker.
+func newTestCluster(t *testing.T, nodes int, timeout time.Duration) []*testNode {
+	broker := NewMemoryBroker()
+	cluster := make([]*testNode, nodes)
+	for i := range cluster {
+		bus := broker.NewBus()
+		node := &testNode{bus: bus, connected: make(chan *Socket, 10)}
+		node.srv, node.ts = newTestServerWithOptions(t, ServerOptions{
+			Adapter: func(namespace *Namespace) IAdapter {
+				return NewClusterAdapter(namespace, ClusterAdapterOptions{Bus: bus, RequestTimeout: timeout})
+			},
+		}, func(socket *Socket) {
+			node.connected <- socket
+		})
+		cluster[i] = node
+	}
+	return cluster
+}
+
+func closeTestCluster(cluster []*testNode) {
+	for _, node := range cluster {
+		node.ts.Close()
+		node.bus.Close()
+	}
+}
+
+func TestServerSideEmit(t *testing.T) {
+	cluster := newTestCluster(t, 3, time.Second)
+	defer closeTestCluster(cluster)
+
+	for i, node := range cluster[1:] {
+		id := float64(i + 1)
+		node.srv.Of("/").OnServerSide("invalidate", func(args []interface{}, ack AckFunc) {
+			ack(args[0], id)
+		})
+	}
+
+	done := make(chan struct{})
+	err := cluster[0].srv.Of("/").ServerSideEmit("invalidate", "users", ServerSideAckFunc(func(responses [][]interface{}, err error) {
+		defer close(done)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(responses) != 2 {
+			t.Errorf("expected 2 responses, got %v", responses)
+			return
+		}
+		sum := 0.0
+		for _, response := range responses {
+			if response[0] != "users" {
+				t.Errorf("unexpected response %v", response)
+			}
+			sum += response[1].(float64)
+		}
+		if sum != 3 {
+			t.Errorf("expected a response of every node, got %v", responses)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestServerSideEmitTimeout(t *testing.T) {
+	cluster := newTestCluster(t, 3, 50*time.Millisecond)
+	defer closeTestCluster(cluster)
+
+	cluster[1].srv.Of("/").OnServerSide("ping", func(args []interface{}, ack AckFunc) {
+		ack("pong")
+	})
+
+	done := make(chan struct{})
+	cluster[0].srv.Of("/").ServerSideEmit("ping", ServerSideAckFunc(func(responses [][]interface{}, err error) {
+		defer close(done)
+		if err != requestTimeoutErr {
+			t.Errorf("expected timeout, got %v", err)
+		}
+		if len(responses) != 1 || responses[0][0] != "pong" {
+			t.Errorf("expected the response of the second node, got %v", responses)
+		}
+	}))
+	<-done
+
+	single := newTestCluster(t, 1, time.Second)
+	defer closeTestCluster(single)
+	if err := single[0].srv.Of("/").ServerSideEmit("ping", ServerSideAckFunc(func(responses [][]interface{}, err error) {
+		if responses != nil || err != nil {
+			t.Errorf("expected no responses without other nodes, got %v %v", responses, err)
+		}
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, ts := newTestServer(t, func(socket *Socket) {})
+	defer ts.Close()
+	if err := srv.Of("/").ServerSideEmit("ping"); err != serverSideEmitErr {
+		t.Fatalf("expected the default adapter to refuse serverSideEmit, got %v", err)
+	}
+}
+
+func TestClusterBroadcast(t *testing.T) {
+	cluster := newTestCluster(t, 2, time.Second)
+	defer closeTestCluster(cluster)
+
+	first := dial(t, cluster[0].ts)
+	defer first.close()
+	second := dial(t, cluster[1].ts)
+	defer second.close()
+	(<-cluster[0].connected).Join("game-42")
+	remote := <-cluster[1].connected
+	remote.Join("game-42")
+
+	nsp := cluster[0].srv.Of("/")
+	nsp.In("game-42").SocketsJoin("lobby")
+	sockets, err := nsp.In("lobby").FetchSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sockets) != 2 {
+		t.Fatalf("expected sockets of both nodes, got %d", len(sockets))
+	}
+
+	nsp.To("lobby").Emit("news", "hi")
+	for _, c := range []*testClient{first, second} {
+		if msg := c.readMessage(); msg != `2["news","hi"]` {
+			t.Fatalf("unexpected message %q", msg)
+		}
+	}
+
+	nsp.Local().To("lobby").Emit("news", "local")
+	if msg := first.readMessage(); msg != `2["news","local"]` {
+		t.Fatalf("unexpected message %q", msg)
+	}
+
+	nsp.To(remote.Id()).DisconnectSockets(false)
+	if msg := second.readMessage(); msg != "1" {
+		t.Fatalf("expected disconnect packet, got %q", msg)
+	}
+	if sockets, _ := nsp.FetchSockets(); len(sockets) != 1 {
+		t.Fatalf("expected one socket left, got %d", len(sockets))
+	}
+}
+
+func TestClusterBroadcastBinary(t *testing.T) {
+	cluster := newTestCluster(t, 1, time.Second)
+	defer closeTestCluster(cluster)
+
+	received := make(chan []byte, 1)
+	other := cluster[0].bus.broker.NewBus()
+	defer other.Close()
+	other.Subscribe("/", func(msg []byte) {
+		received <- msg
+	})
+
+	cluster[0].srv.Of("/").Emit("file", []byte{1, 2, 3})
+	msg := &clusterMessage{}
+	if err := json.Unmarshal(<-received, msg); err != nil {
+		t.Fatal(err)
+	}
+	packet, err := (msgpack.Decoder{}).Decode(msg.Packet, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, _ := packet.Data.([]interface{})
+	if len(args) != 2 {
+		t.Fatalf("unexpected packet data %#v", packet.Data)
+	}
+	if b, ok := args[1].([]byte); !ok || !bytes.Equal(b, []byte{1, 2, 3}) {
+		t.Fatalf("expected the binary argument to be kept, got %#v", packet.Data)
+	}
+}
+
+func TestClusterPublishError(t *testing.T) {
+	cluster := newTestCluster(t, 2, time.Second)
+	defer closeTestCluster(cluster)
+
+	c := dial(t, cluster[0].ts)
+	defer c.close()
+	<-cluster[0].connected
+	cluster[0].bus.Close()
+
+	nsp := cluster[0].srv.Of("/")
+	if err := nsp.Emit("news", "hi"); err != busClosedErr {
+		t.Fatalf("expected the publish error, got %v", err)
+	}
+	if msg := c.readMessage(); msg != `2["news","hi"]` {
+		t.Fatalf("expected the local sockets to get the event anyway, got %q", msg)
+	}
+	if err := nsp.ServerSideEmit("ping"); err != busClosedErr {
+		t.Fatalf("expected the publish error, got %v", err)
+	}
+}
+
+func TestServerSideHandlerFetchSockets(t *testing.T) {
+	cluster := newTestCluster(t, 2, time.Second)
+	defer closeTestCluster(cluster)
+
+	c := dial(t, cluster[0].ts)
+	defer c.close()
+	<-cluster[0].connected
+
+	// the handler waits for the first node, while that node waits for its response.
+	nsp := cluster[1].srv.Of("/")
+	nsp.OnServerSide("count", func(args []interface{}, ack AckFunc) {
+		sockets, err := nsp.FetchSockets()
+		if err != nil {
+			t.Error(err)
+		}
+		ack(len(sockets))
+	})
+
+	done := make(chan struct{})
+	cluster[0].srv.Of("/").ServerSideEmit("count", ServerSideAckFunc(func(responses [][]interface{}, err error) {
+		defer close(done)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(responses) != 1 || responses[0][0] != 1.0 {
+			t.Errorf("expected the socket of the first node, got %v", responses)
+		}
+	}))
+	<-done
+}
+
+func TestMemoryBus(t *testing.T) {
+	broker := NewMemoryBroker()
+	first := broker.NewBus()
+	second := broker.NewBus()
+
+	// publishing doesn't wait for a slow subscriber.
+	block := make(chan struct{})
+	received := make(chan []byte, 1)
+	second.Subscribe("/", func(msg []byte) {
+		<-block
+		select {
+		case received <- msg:
+		default:
+		}
+	})
+	for i := 0; i < 2000; i++ {
+		if err := first.Publish("/", []byte("msg")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(block)
+	if msg := <-received; string(msg) != "msg" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+
+	second.Close()
+	if count := first.ServerCount(); count != 1 {
+		t.Fatalf("expected 1 node after close, got %d", count)
+	}
+	if err := second.Publish("/", []byte("msg")); err != busClosedErr {
+		t.Fatalf("expected a closed bus, got %v", err)
+	}
+	first.Close()
+}
@@ -0,0 +1,81 @@
+package sio
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/adrianmxb/goseio/pkg/sio/parser"
+	"github.com/adrianmxb/goseio/pkg/stream"
+	"net"
+)
+
+var invalidConnDataErr = errors.New("conn data is neither binary nor base64")
+
+// NewConn returns a net.Conn tunneling a byte stream through event, e.g. to run another protocol over a
+// namespace. Every Write is emitted as one or more events with the data as only argument, Read returns the
+// data of the events the client sends. The data is a binary argument, or a base64 string with parsers that
+// don't support binary data like parser.JSONParser. Other data breaks the stream, the socket gets disconnected
+// and Read fails.
+//
+// Closing the conn disconnects the socket from the namespace, once it is disconnected Read returns io.EOF.
+// Once stream.ReadBuffer events are waiting to be read, the handler of the event blocks until the conn is read,
+// which slows down the client. If that takes longer than the ping interval and timeout of the eio server, the
+// socket gets disconnected and Read fails with stream.ErrReadBufferFull.
+func NewConn(socket *Socket, event string) net.Conn {
+	conn := stream.New(stream.Options{
+		Send: func(data []byte, done func(err error)) error {
+			return socket.client.packetDone(parser.Packet{
+				Type:      parser.Event,
+				Namespace: socket.namespace.name,
+				Data:      []interface{}{event, data},
+			}, FlagCompress, done)
+		},
+		Close: func() error {
+			socket.Disconnect(false)
+			return nil
+		},
+		Local:  stream.Addr{Net: "sio", Address: socket.id},
+		Remote: stream.Addr{Net: "sio", Address: socket.client.conn.RemoteAddr()},
+	})
+
+	// the tunnel is broken once a message is lost.
+	broken := func(err error) {
+		socket.namespace.server.logger.Warn("conn broken, disconnecting", "sid", socket.id,
+			"namespace", socket.namespace.name, "error", err)
+		conn.CloseReadError(err)
+		socket.Disconnect(false)
+	}
+
+	socket.On(event, func(socket *Socket, args []interface{}, ack AckFunc) {
+		if len(args) == 0 {
+			broken(invalidConnDataErr)
+			return
+		}
+		var data []byte
+		switch arg := args[0].(type) {
+		case []byte:
+			data = arg
+		case string:
+			b, err := base64.StdEncoding.DecodeString(arg)
+			if err != nil {
+				broken(invalidConnDataErr)
+				return
+			}
+			data = b
+		default:
+			broken(invalidConnDataErr)
+			return
+		}
+
+		eio := socket.namespace.server.eio
+		ctx, cancel := context.WithTimeout(socket.client.conn.Context(), eio.PingInterval+eio.PingTimeout)
+		defer cancel()
+		if err := conn.Push(ctx, data); err == context.DeadlineExceeded {
+			broken(stream.ErrReadBufferFull)
+		}
+	})
+	socket.On("disconnect", func(socket *Socket, args []interface{}, ack AckFunc) {
+		conn.CloseRead()
+	})
+	return conn
+}
@@ -3,10 +3,16 @@ package sio
 const (
 	FlagJson     = 1 << 0
 	FlagVolatile = 1 << 1
-	FlagLocal    = 1 << 2
+	// FlagLocal restricts a broadcast to the sockets of the current node.
+	FlagLocal = 1 << 2
+	// FlagCompress allows the transport to compress the packet, it is set by default.
+	FlagCompress = 1 << 3
 )
 
-type EmitData struct {
-	flags int
-	rooms map[string]bool
+// BroadcastOptions selects the sockets a broadcast operation is applied to.
+// No rooms means every socket of the namespace.
+type BroadcastOptions struct {
+	Rooms  map[string]struct{}
+	Except map[string]struct{}
+	Flags  int
 }
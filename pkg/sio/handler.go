@@ -0,0 +1,143 @@
+package sio
+
+import (
+	"context"
+	"fmt"
+	"github.com/adrianmxb/goseio/pkg/eio"
+	"reflect"
+)
+
+var json = eio.JSON
+
+var socketType = reflect.TypeOf((*Socket)(nil))
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// eventHandler is a registered handler, ctx carries the sio.event span.
+type eventHandler func(ctx context.Context, socket *Socket, args []interface{}, ack AckFunc)
+
+// ArgumentError is sent to the client as "error" event if the arguments of an event can't be converted
+// into the parameter types of the registered handler.
+type ArgumentError struct {
+	Event string
+	Index int
+	Err   error
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("event %q: argument %d: %v", e.Event, e.Index, e.Err)
+}
+
+// newEventHandler wraps handler into an eventHandler. Besides EventHandlerFunc itself, every function
+// taking a *Socket as first parameter is accepted, e.g. func(s *sio.Socket, msg ChatMsg, ack func(Reply)).
+// Other functions are rejected with an error.
+// If the second parameter is a context.Context, it gets the context of the event, carrying its span.
+// The event arguments get unmarshaled into the parameter types, missing arguments are passed as zero values.
+// If the last parameter is a function, it is used as acknowledgement callback; it does nothing if the client
+// didn't ask for an acknowledgement.
+func newEventHandler(event string, handler interface{}) (eventHandler, error) {
+	switch h := handler.(type) {
+	case EventHandlerFunc:
+		return h.eventHandler(), nil
+	case func(*Socket, []interface{}, AckFunc):
+		return EventHandlerFunc(h).eventHandler(), nil
+	}
+
+	if handler == nil {
+		return nil, fmt.Errorf("sio: handler for event %q is nil", event)
+	}
+	fn := reflect.ValueOf(handler)
+	t := fn.Type()
+	if t.Kind() != reflect.Func || t.NumIn() == 0 || t.In(0) != socketType || t.IsVariadic() || t.NumOut() != 0 {
+		return nil, fmt.Errorf("sio: handler for event %q must be a func(*sio.Socket, ...) without results, got %s",
+			event, t)
+	}
+
+	argc := t.NumIn() - 1
+	withContext := argc > 0 && t.In(1) == contextType
+	if withContext {
+		argc--
+	}
+	var ackType reflect.Type
+	if argc > 0 && t.In(t.NumIn()-1).Kind() == reflect.Func {
+		ackType = t.In(t.NumIn() - 1)
+		argc--
+	}
+
+	first := 1
+	if withContext {
+		first = 2
+	}
+
+	return func(ctx context.Context, socket *Socket, args []interface{}, ack AckFunc) {
+		in := make([]reflect.Value, 0, t.NumIn())
+		in = append(in, reflect.ValueOf(socket))
+		if withContext {
+			in = append(in, reflect.ValueOf(&ctx).Elem())
+		}
+
+		for i := 0; i < argc; i++ {
+			value := reflect.New(t.In(i + first)).Elem()
+			if i < len(args) {
+				if err := convertArg(args[i], value); err != nil {
+					socket.error((&ArgumentError{Event: event, Index: i, Err: err}).Error())
+					return
+				}
+			}
+			in = append(in, value)
+		}
+
+		if ackType != nil {
+			in = append(in, makeAck(ackType, ack))
+		}
+
+		fn.Call(in)
+	}, nil
+}
+
+func (h EventHandlerFunc) eventHandler() eventHandler {
+	return func(ctx context.Context, socket *Socket, args []interface{}, ack AckFunc) {
+		h(socket, args, ack)
+	}
+}
+
+// convertArg stores a decoded json value in target, going through json if the types don't match directly.
+func convertArg(arg interface{}, target reflect.Value) error {
+	if arg == nil {
+		return nil
+	}
+
+	if v := reflect.ValueOf(arg); v.Type().AssignableTo(target.Type()) {
+		target.Set(v)
+		return nil
+	}
+
+	b, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, target.Addr().Interface())
+}
+
+func makeAck(ackType reflect.Type, ack AckFunc) reflect.Value {
+	return reflect.MakeFunc(ackType, func(in []reflect.Value) []reflect.Value {
+		if ack != nil {
+			args := make([]interface{}, 0, len(in))
+			for i, value := range in {
+				if ackType.IsVariadic() && i == len(in)-1 {
+					for j := 0; j < value.Len(); j++ {
+						args = append(args, value.Index(j).Interface())
+					}
+					break
+				}
+				args = append(args, value.Interface())
+			}
+			ack(args...)
+		}
+
+		out := make([]reflect.Value, ackType.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(ackType.Out(i))
+		}
+		return out
+	})
+}
@@ -0,0 +1,20 @@
+package sio
+
+import "time"
+
+// Metrics receives the events of a server, e.g. metrics.Prometheus. The methods get called concurrently.
+// If it implements eio.Metrics as well, it is used for the eio server too.
+type Metrics interface {
+	// Connect is called once a socket connected to the namespace.
+	Connect(namespace string)
+	// Disconnect is called once a socket left the namespace.
+	Disconnect(namespace string, reason string)
+	// Ack is called once a client acknowledged an event, latency is the time since it was emitted.
+	Ack(namespace string, latency time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Connect(namespace string)                    {}
+func (nopMetrics) Disconnect(namespace string, reason string)  {}
+func (nopMetrics) Ack(namespace string, latency time.Duration) {}
@@ -1,10 +1,8 @@
 package sio
 
-type NamespaceMiddleware func(socket *Socket, next NamespaceMiddleware) NamespaceMiddleware
+import "sync"
 
-type F struct {
-	rooms []string
-}
+type NamespaceMiddleware func(socket *Socket, next NamespaceMiddleware) NamespaceMiddleware
 
 type Namespace struct {
 	//keep ackId up here so we have correct 64bit alignment on ARM processors
@@ -12,10 +10,14 @@ type Namespace struct {
 
 	name      string
 	server    *Server
+	lock      sync.RWMutex
 	sockets   map[string]*Socket
 	connected map[string]*Socket
 	OnConnect NamespaceMiddleware
 	adapter   IAdapter
+
+	serverSideLock     sync.RWMutex
+	serverSideHandlers map[string][]ServerSideHandlerFunc
 }
 
 func NewNamespace(server *Server, name string) *Namespace {
@@ -25,19 +27,144 @@ func NewNamespace(server *Server, name string) *Namespace {
 		server:    server,
 		sockets:   make(map[string]*Socket),
 		connected: make(map[string]*Socket),
+
+		serverSideHandlers: make(map[string][]ServerSideHandlerFunc),
 	}
-	nsp.adapter = NewAdapter(nsp)
+	nsp.adapter = server.newAdapter(nsp)
 	return nsp
 }
 
+func (n *Namespace) Name() string {
+	return n.name
+}
+
 func (n *Namespace) Add(client *Client, query string) *Socket {
 	socket := NewSocket(n, client, query)
 
+	n.lock.Lock()
 	n.sockets[socket.id] = socket
 	n.connected[socket.id] = socket
+	n.lock.Unlock()
+
+	socket.onConnect()
 
 	// TODO: fire namespace related events. (connect, connection)
-	n.OnConnect(socket, nil)
+	if n.OnConnect != nil {
+		n.OnConnect(socket, nil)
+	}
 
 	return socket
 }
+
+func (n *Namespace) remove(socket *Socket) {
+	defer n.lock.Unlock()
+	n.lock.Lock()
+	delete(n.sockets, socket.id)
+	delete(n.connected, socket.id)
+}
+
+// SocketCount returns the number of sockets connected to the namespace on this node.
+func (n *Namespace) SocketCount() int {
+	defer n.lock.RUnlock()
+	n.lock.RLock()
+	return len(n.connected)
+}
+
+// RoomCount returns the number of rooms of the sockets on this node, without the rooms named after the socket
+// ids. ok is false if the adapter isn't a RoomLister.
+func (n *Namespace) RoomCount() (count int, ok bool) {
+	lister, ok := n.adapter.(RoomLister)
+	if !ok {
+		return 0, false
+	}
+	rooms := lister.GetRooms()
+
+	defer n.lock.RUnlock()
+	n.lock.RLock()
+	for _, room := range rooms {
+		if _, ok := n.sockets[room]; !ok {
+			count++
+		}
+	}
+	return count, true
+}
+
+// To targets the sockets in the given rooms.
+func (n *Namespace) To(rooms ...string) *BroadcastOperator {
+	return newBroadcastOperator(n).To(rooms...)
+}
+
+// In is an alias for To.
+func (n *Namespace) In(rooms ...string) *BroadcastOperator {
+	return n.To(rooms...)
+}
+
+// Except targets every socket except the ones in the given rooms.
+func (n *Namespace) Except(rooms ...string) *BroadcastOperator {
+	return newBroadcastOperator(n).Except(rooms...)
+}
+
+// Local restricts the following operation to the sockets of the current node.
+func (n *Namespace) Local() *BroadcastOperator {
+	return newBroadcastOperator(n).Local()
+}
+
+// Emit sends an event to every socket of the namespace.
+func (n *Namespace) Emit(event string, args ...interface{}) error {
+	return newBroadcastOperator(n).Emit(event, args...)
+}
+
+func (n *Namespace) FetchSockets() ([]*RemoteSocket, error) {
+	return newBroadcastOperator(n).FetchSockets()
+}
+
+func (n *Namespace) SocketsJoin(rooms ...string) {
+	newBroadcastOperator(n).SocketsJoin(rooms...)
+}
+
+func (n *Namespace) SocketsLeave(rooms ...string) {
+	newBroadcastOperator(n).SocketsLeave(rooms...)
+}
+
+func (n *Namespace) DisconnectSockets(close bool) {
+	newBroadcastOperator(n).DisconnectSockets(close)
+}
+
+// Adapter returns the adapter managing the rooms of the namespace.
+func (n *Namespace) Adapter() IAdapter {
+	return n.adapter
+}
+
+// OnServerSide registers a handler for events sent by other nodes with ServerSideEmit.
+func (n *Namespace) OnServerSide(event string, handler ServerSideHandlerFunc) {
+	defer n.serverSideLock.Unlock()
+	n.serverSideLock.Lock()
+	n.serverSideHandlers[event] = append(n.serverSideHandlers[event], handler)
+}
+
+// ServerSideEmit sends an event to the other nodes of the cluster. If the last argument is a
+// ServerSideAckFunc, it is called with the acknowledgements of every other node.
+func (n *Namespace) ServerSideEmit(event string, args ...interface{}) error {
+	if reservedEvents[event] {
+		return reservedEvent
+	}
+
+	var ack ServerSideAckFunc
+	if len(args) > 0 {
+		if fn, ok := args[len(args)-1].(ServerSideAckFunc); ok {
+			ack = fn
+			args = args[:len(args)-1]
+		}
+	}
+	return n.adapter.ServerSideEmit(event, args, ack)
+}
+
+func (n *Namespace) onServerSideEmit(event string, args []interface{}, ack AckFunc) {
+	n.serverSideLock.RLock()
+	handlers := n.serverSideHandlers[event]
+	n.serverSideLock.RUnlock()
+
+	for _, handler := range handlers {
+		handler(args, ack)
+	}
+}
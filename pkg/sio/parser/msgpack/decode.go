@@ -0,0 +1,223 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var shortErr = errors.New("msgpack: unexpected end of data")
+var depthErr = errors.New("msgpack: exceeded max depth")
+
+// maxDepth limits the nesting of arrays and maps, so a message can't exhaust the stack.
+const maxDepth = 1000
+
+type decoder struct {
+	buf   []byte
+	off   int
+	depth int
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.buf)-d.off < n {
+		return nil, shortErr
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+func (d *decoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+// decode reads the next value. Maps are decoded into map[string]interface{}, integers into int64
+// (uint64 if they don't fit) and binary data into []byte. Decoder.Decode normalizes the event data.
+func (d *decoder) decode() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.decodeMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.decodeArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.decodeString(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		l, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(l))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xc7, 0xc8, 0xc9:
+		l, err := d.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(int(l))
+	case 0xca:
+		v, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), nil
+	case 0xd0:
+		v, err := d.uint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := d.uint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := d.uint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := d.uint(8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		l, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(int(l))
+	case 0xdc, 0xdd:
+		l, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(l))
+	case 0xde, 0xdf:
+		l, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(l))
+	}
+
+	return nil, fmt.Errorf("msgpack: invalid type 0x%x", c)
+}
+
+func (d *decoder) decodeString(l int) (interface{}, error) {
+	b, err := d.next(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *decoder) decodeArray(l int) (interface{}, error) {
+	if l > len(d.buf)-d.off {
+		return nil, shortErr
+	}
+	if d.depth++; d.depth > maxDepth {
+		return nil, depthErr
+	}
+	defer func() { d.depth-- }()
+	arr := make([]interface{}, l)
+	for i := range arr {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+func (d *decoder) decodeMap(l int) (interface{}, error) {
+	if l > len(d.buf)-d.off {
+		return nil, shortErr
+	}
+	if d.depth++; d.depth > maxDepth {
+		return nil, depthErr
+	}
+	defer func() { d.depth-- }()
+	m := make(map[string]interface{}, l)
+	for i := 0; i < l; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// decodeExt understands the extensions notepack.io writes: undefined and dates.
+func (d *decoder) decodeExt(l int) (interface{}, error) {
+	t, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.next(l)
+	if err != nil {
+		return nil, err
+	}
+
+	if int8(t[0]) != extType {
+		return nil, fmt.Errorf("msgpack: unsupported extension type %d", int8(t[0]))
+	}
+
+	switch l {
+	case 1:
+		return nil, nil
+	case 8:
+		ms := math.Float64frombits(binary.BigEndian.Uint64(b))
+		return time.Unix(0, int64(ms*float64(time.Millisecond))), nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported extension length %d", l)
+}
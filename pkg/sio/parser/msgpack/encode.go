@@ -0,0 +1,411 @@
+package msgpack
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// extension type notepack.io uses for undefined and dates.
+const extType = 0
+
+type encoder struct {
+	buf   []byte
+	depth int
+}
+
+type jsonMarshaler interface {
+	MarshalJSON() ([]byte, error)
+}
+
+func (e *encoder) encode(v interface{}) error {
+	if e.depth++; e.depth > maxDepth {
+		return depthErr
+	}
+	defer func() { e.depth-- }()
+
+	switch v := v.(type) {
+	case nil:
+		e.buf = append(e.buf, 0xc0)
+	case bool:
+		if v {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case int:
+		e.encodeInt(int64(v))
+	case int8:
+		e.encodeInt(int64(v))
+	case int16:
+		e.encodeInt(int64(v))
+	case int32:
+		e.encodeInt(int64(v))
+	case int64:
+		e.encodeInt(v)
+	case uint:
+		e.encodeUint(uint64(v))
+	case uint8:
+		e.encodeUint(uint64(v))
+	case uint16:
+		e.encodeUint(uint64(v))
+	case uint32:
+		e.encodeUint(uint64(v))
+	case uint64:
+		e.encodeUint(v)
+	case float32:
+		e.encodeFloat(float64(v))
+	case float64:
+		e.encodeFloat(v)
+	case string:
+		e.encodeString(v)
+	case []byte:
+		e.encodeBytes(v)
+	case time.Time:
+		e.buf = append(e.buf, 0xd7, extType)
+		e.buf = appendUint64(e.buf, math.Float64bits(float64(v.UnixNano())/float64(time.Millisecond)))
+	case []interface{}:
+		e.encodeArrayHeader(len(v))
+		for _, item := range v {
+			if err := e.encode(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		e.encodeMapHeader(len(v))
+		for key, item := range v {
+			e.encodeString(key)
+			if err := e.encode(item); err != nil {
+				return err
+			}
+		}
+	case jsonMarshaler:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(b, &generic); err != nil {
+			return err
+		}
+		return e.encode(generic)
+	case encoding.TextMarshaler:
+		b, err := v.MarshalText()
+		if err != nil {
+			return err
+		}
+		e.encodeString(string(b))
+	default:
+		return e.encodeValue(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+// encodeValue encodes structs, typed slices and maps like they would be with the json parser, except for
+// binary data, which is kept as such instead of becoming a base64 string.
+func (e *encoder) encodeValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		return e.encode(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.encodeFloat(v.Float())
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return e.encode(nil)
+		}
+		return e.encodeElem(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return e.encode(nil)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBytes(v.Bytes())
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		e.encodeArrayHeader(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encodeElem(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return e.encode(nil)
+		}
+		e.encodeMapHeader(v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encodeKey(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encodeElem(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := structFields(v, nil)
+		e.encodeMapHeader(len(fields))
+		for _, f := range fields {
+			e.encodeString(f.name)
+			if err := e.encodeElem(f.value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// encodeKey writes map keys as strings, like json does.
+func (e *encoder) encodeKey(key reflect.Value) error {
+	if key.Kind() != reflect.String && key.CanInterface() {
+		if k, ok := key.Interface().(encoding.TextMarshaler); ok {
+			b, err := k.MarshalText()
+			if err != nil {
+				return err
+			}
+			e.encodeString(string(b))
+			return nil
+		}
+	}
+	e.encodeString(fmt.Sprint(key))
+	return nil
+}
+
+// encodeElem encodes an element of a struct, slice or map. The fields of unexported embedded structs can't be
+// turned into an interface{}, so they are encoded by their kind only.
+func (e *encoder) encodeElem(v reflect.Value) error {
+	if v.CanInterface() {
+		return e.encode(v.Interface())
+	}
+	if e.depth++; e.depth > maxDepth {
+		return depthErr
+	}
+	defer func() { e.depth-- }()
+	return e.encodeValue(v)
+}
+
+type field struct {
+	name  string
+	value reflect.Value
+	depth int
+}
+
+// structFields returns the fields json would encode, including the ones of embedded structs. Like with json,
+// a field hides the fields of the same name nested deeper.
+func structFields(v reflect.Value, index []int) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+		fv := v.Field(i)
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				for _, f := range structFields(fv, append(index, i)) {
+					fields = addField(fields, f)
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if strings.Contains(opts, ",omitempty") && isEmpty(fv) {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = addField(fields, field{name: name, value: fv, depth: len(index)})
+	}
+	return fields
+}
+
+func addField(fields []field, f field) []field {
+	for i := range fields {
+		if fields[i].name == f.name {
+			if f.depth < fields[i].depth {
+				fields[i] = f
+			}
+			return fields
+		}
+	}
+	return append(fields, f)
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func (e *encoder) encodeInt(v int64) {
+	switch {
+	case v >= 0:
+		e.encodeUint(uint64(v))
+	case v >= -32:
+		e.buf = append(e.buf, byte(v))
+	case v >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		e.buf = append(e.buf, 0xd1)
+		e.buf = appendUint16(e.buf, uint16(v))
+	case v >= math.MinInt32:
+		e.buf = append(e.buf, 0xd2)
+		e.buf = appendUint32(e.buf, uint32(v))
+	default:
+		e.buf = append(e.buf, 0xd3)
+		e.buf = appendUint64(e.buf, uint64(v))
+	}
+}
+
+func (e *encoder) encodeUint(v uint64) {
+	switch {
+	case v < 128:
+		e.buf = append(e.buf, byte(v))
+	case v <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		e.buf = append(e.buf, 0xcd)
+		e.buf = appendUint16(e.buf, uint16(v))
+	case v <= math.MaxUint32:
+		e.buf = append(e.buf, 0xce)
+		e.buf = appendUint32(e.buf, uint32(v))
+	default:
+		e.buf = append(e.buf, 0xcf)
+		e.buf = appendUint64(e.buf, v)
+	}
+}
+
+// encodeFloat writes integral numbers as integers, just like notepack.io does with javascript numbers.
+func (e *encoder) encodeFloat(v float64) {
+	if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+		e.encodeInt(int64(v))
+		return
+	}
+	e.buf = append(e.buf, 0xcb)
+	e.buf = appendUint64(e.buf, math.Float64bits(v))
+}
+
+func (e *encoder) encodeString(v string) {
+	l := len(v)
+	switch {
+	case l < 32:
+		e.buf = append(e.buf, 0xa0|byte(l))
+	case l <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, 0xda)
+		e.buf = appendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, 0xdb)
+		e.buf = appendUint32(e.buf, uint32(l))
+	}
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) encodeBytes(v []byte) {
+	l := len(v)
+	switch {
+	case l <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, 0xc5)
+		e.buf = appendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, 0xc6)
+		e.buf = appendUint32(e.buf, uint32(l))
+	}
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) encodeArrayHeader(l int) {
+	switch {
+	case l < 16:
+		e.buf = append(e.buf, 0x90|byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, 0xdc)
+		e.buf = appendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, 0xdd)
+		e.buf = appendUint32(e.buf, uint32(l))
+	}
+}
+
+func (e *encoder) encodeMapHeader(l int) {
+	switch {
+	case l < 16:
+		e.buf = append(e.buf, 0x80|byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, 0xde)
+		e.buf = appendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, 0xdf)
+		e.buf = appendUint32(e.buf, uint32(l))
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var tmp [2]byte
+	binary.BigEndian.PutUint16(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], v)
+	return append(b, tmp[:]...)
+}
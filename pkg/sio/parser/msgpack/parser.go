@@ -0,0 +1,117 @@
+// Package msgpack implements a socket.io parser compatible with socket.io-msgpack-parser.
+// Every packet is sent as a single binary engine.io message.
+package msgpack
+
+import (
+	"errors"
+	"github.com/adrianmxb/goseio/pkg/sio/parser"
+)
+
+var packetErr = errors.New("invalid packet")
+
+type Parser struct{}
+
+func (Parser) NewEncoder() parser.Encoder {
+	return Encoder{}
+}
+
+func (Parser) NewDecoder() parser.Decoder {
+	return Decoder{}
+}
+
+type Encoder struct{}
+
+func (Encoder) Encode(packet parser.Packet) ([]byte, bool, error) {
+	fields := 2
+	if packet.Data != nil {
+		fields++
+	}
+	if packet.Id != nil {
+		fields++
+	}
+
+	e := &encoder{}
+	e.encodeMapHeader(fields)
+	e.encodeString("type")
+	e.encodeInt(int64(packet.Type))
+	if packet.Data != nil {
+		e.encodeString("data")
+		if err := e.encode(packet.Data); err != nil {
+			return nil, true, err
+		}
+	}
+	e.encodeString("nsp")
+	e.encodeString(packet.Namespace)
+	if packet.Id != nil {
+		e.encodeString("id")
+		e.encodeInt(int64(*packet.Id))
+	}
+
+	return e.buf, true, nil
+}
+
+type Decoder struct{}
+
+func (Decoder) Decode(data []byte, isBinary bool) (*parser.Packet, error) {
+	d := &decoder{buf: data}
+	v, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, packetErr
+	}
+
+	t, ok := m["type"].(int64)
+	if !ok {
+		return nil, packetErr
+	}
+
+	packet := &parser.Packet{
+		Type:      parser.PacketTypes(t),
+		Namespace: "/",
+		Data:      normalize(m["data"]),
+	}
+
+	if nsp, ok := m["nsp"]; ok {
+		if packet.Namespace, ok = nsp.(string); !ok {
+			return nil, packetErr
+		}
+	}
+
+	if id, ok := m["id"]; ok && id != nil {
+		i, ok := id.(int64)
+		if !ok {
+			return nil, packetErr
+		}
+		n := int(i)
+		packet.Id = &n
+	}
+
+	if err := parser.Validate(packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// normalize turns the integers of v into float64, so event handlers see the numbers like with
+// parser.JSONParser. Integers beyond 2^53 lose precision, as they do in javascript.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalize(value)
+		}
+	}
+	return v
+}
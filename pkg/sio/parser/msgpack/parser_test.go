@@ -0,0 +1,147 @@
+package msgpack
+
+import (
+	"bytes"
+	"github.com/adrianmxb/goseio/pkg/sio/parser"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	data, isBinary, err := Encoder{}.Encode(parser.Packet{
+		Type:      parser.Event,
+		Namespace: "/",
+		Data:      []interface{}{"hi", 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isBinary {
+		t.Fatal("expected binary message")
+	}
+
+	expected := []byte{0x83,
+		0xa4, 't', 'y', 'p', 'e', 0x02,
+		0xa4, 'd', 'a', 't', 'a', 0x92, 0xa2, 'h', 'i', 0x01,
+		0xa3, 'n', 's', 'p', 0xa1, '/'}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected % x, got % x", expected, data)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// notepack.encode({type: 3, data: [{a: 1.5, b: -200, c: undefined}], nsp: "/chat", id: 300})
+	data := []byte{0x84,
+		0xa4, 't', 'y', 'p', 'e', 0x03,
+		0xa4, 'd', 'a', 't', 'a', 0x91, 0x83,
+		0xa1, 'a', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xa1, 'b', 0xd1, 0xff, 0x38,
+		0xa1, 'c', 0xd4, 0x00, 0x00,
+		0xa3, 'n', 's', 'p', 0xa5, '/', 'c', 'h', 'a', 't',
+		0xa2, 'i', 'd', 0xcd, 0x01, 0x2c}
+
+	pack, err := Decoder{}.Decode(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pack.Type != parser.Ack || pack.Namespace != "/chat" || pack.Id == nil || *pack.Id != 300 {
+		t.Fatalf("unexpected packet %+v", pack)
+	}
+	// integers are decoded like with the json parser.
+	expected := []interface{}{map[string]interface{}{"a": 1.5, "b": -200.0, "c": nil}}
+	if !reflect.DeepEqual(pack.Data, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, pack.Data)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	type base struct {
+		Id   int    `json:"id"`
+		Text string `json:"text"`
+	}
+	type msg struct {
+		base
+		Text   string            `json:"text"`
+		Image  []byte            `json:"image"`
+		Sizes  map[int]float64   `json:"sizes,omitempty"`
+		Hidden string            `json:"-"`
+		Extra  *string           `json:"extra,omitempty"`
+		Tags   []string          `json:"tags"`
+		Meta   map[string][]byte `json:"meta,omitempty"`
+		secret string
+	}
+	date := time.Unix(1500000000, 0)
+	values := []interface{}{
+		nil, true, false, int64(-1), int64(-33), int64(math.MinInt64), int64(200), int64(70000),
+		uint64(math.MaxUint64), 0.25, "", string(make([]byte, 40)), string(make([]byte, 300)),
+		[]byte{1, 2, 3}, date, make([]interface{}, 20),
+	}
+
+	for _, value := range values {
+		e := &encoder{}
+		if err := e.encode(value); err != nil {
+			t.Fatal(err)
+		}
+		d := &decoder{buf: e.buf}
+		decoded, err := d.decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tm, ok := value.(time.Time); ok {
+			value = tm.Local()
+		}
+		if !reflect.DeepEqual(value, decoded) {
+			t.Fatalf("expected %#v, got %#v", value, decoded)
+		}
+	}
+
+	e := &encoder{}
+	if err := e.encode(msg{base: base{Id: 1, Text: "shadowed"}, Text: "hi", Image: []byte{1, 2}, Hidden: "x", secret: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	d := &decoder{buf: e.buf}
+	expected := map[string]interface{}{"id": int64(1), "text": "hi", "image": []byte{1, 2}, "tags": nil}
+	if decoded, _ := d.decode(); !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("expected struct to be encoded like json with binary data, got %#v", decoded)
+	}
+}
+
+func TestEncodeDepth(t *testing.T) {
+	type node struct {
+		Next *node
+	}
+	n := &node{}
+	n.Next = n
+	if err := (&encoder{}).encode(n); err != depthErr {
+		t.Fatalf("expected %v, got %v", depthErr, err)
+	}
+}
+
+func TestDecodeDepth(t *testing.T) {
+	data := append(bytes.Repeat([]byte{0x91}, maxDepth), 0xc0)
+	if _, err := (&decoder{buf: data}).decode(); err != nil {
+		t.Fatal(err)
+	}
+	data = append(bytes.Repeat([]byte{0x91}, maxDepth+1), 0xc0)
+	if _, err := (&decoder{buf: data}).decode(); err != depthErr {
+		t.Fatalf("expected %v, got %v", depthErr, err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0x81, 0xa4, 't', 'y', 'p', 'e'},
+		{0x91, 0x02},
+		{0x82, 0xa4, 't', 'y', 'p', 'e', 0x02, 0xa4, 'd', 'a', 't', 'a', 0x90},
+		{0xdd, 0xff, 0xff, 0xff, 0xff},
+		bytes.Repeat([]byte{0x91}, maxDepth+1),
+	} {
+		if _, err := (Decoder{}).Decode(data, true); err == nil {
+			t.Fatalf("expected error for % x", data)
+		}
+	}
+}
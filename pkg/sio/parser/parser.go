@@ -2,7 +2,9 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"strconv"
 )
 
@@ -14,6 +16,56 @@ type Packet struct {
 	Data        interface{}
 }
 
+var decodeErr = errors.New("invalid packet")
+var binaryErr = errors.New("binary packets are not supported")
+
+// Parser creates the encoders and decoders used to turn packets into engine.io messages and back.
+// A decoder is created per client and may keep state between messages, encoders are shared.
+type Parser interface {
+	NewEncoder() Encoder
+	NewDecoder() Decoder
+}
+
+type Encoder interface {
+	// Encode returns the engine.io message the packet is sent with.
+	Encode(packet Packet) (data []byte, isBinary bool, err error)
+}
+
+type Decoder interface {
+	// Decode parses a single engine.io message.
+	Decode(data []byte, isBinary bool) (*Packet, error)
+}
+
+// JSONParser is the default socket.io parser encoding packets as text.
+type JSONParser struct{}
+
+func (JSONParser) NewEncoder() Encoder {
+	return jsonEncoder{}
+}
+
+func (JSONParser) NewDecoder() Decoder {
+	return jsonDecoder{}
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(packet Packet) ([]byte, bool, error) {
+	var buf bytes.Buffer
+	if err := Encode(packet, bufio.NewWriter(&buf)); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), false, nil
+}
+
+type jsonDecoder struct{}
+
+func (jsonDecoder) Decode(data []byte, isBinary bool) (*Packet, error) {
+	if isBinary {
+		return nil, binaryErr
+	}
+	return Decode(data)
+}
+
 func Encode(packet Packet, writer *bufio.Writer) error {
 	switch packet.Type {
 	case BinaryEvent:
@@ -48,7 +100,89 @@ func strEncode(packet Packet, writer *bufio.Writer) error {
 			return err //RETURN ERROR PACKET!
 		}
 		writer.Write(b)
-		writer.Flush()
+	}
+	return writer.Flush()
+}
+
+// Decode parses a single string encoded packet, e.g. `2/chat,12["message","hi"]`.
+// Event and ack data is decoded into a []interface{}.
+func Decode(data []byte) (*Packet, error) {
+	if len(data) == 0 || data[0] < '0' || data[0] > '0'+byte(BinaryAck) {
+		return nil, decodeErr
+	}
+
+	packet := &Packet{
+		Type:      PacketTypes(data[0] - '0'),
+		Namespace: "/",
+	}
+	i := 1
+
+	if packet.Type == BinaryEvent || packet.Type == BinaryAck {
+		start := i
+		for i < len(data) && data[i] != '-' {
+			i++
+		}
+		if i == len(data) {
+			return nil, decodeErr
+		}
+		packet.Attachments = string(data[start:i])
+		i++
+	}
+
+	if i < len(data) && data[i] == '/' {
+		start := i
+		for i < len(data) && data[i] != ',' {
+			i++
+		}
+		packet.Namespace = string(data[start:i])
+		if i < len(data) {
+			i++
+		}
+	}
+
+	start := i
+	for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+	if i > start {
+		id, err := strconv.Atoi(string(data[start:i]))
+		if err != nil {
+			return nil, decodeErr
+		}
+		packet.Id = &id
+	}
+
+	if i < len(data) {
+		if err := json.Unmarshal(data[i:], &packet.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := Validate(packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// Validate checks that the data of event and ack packets has the expected shape.
+func Validate(packet *Packet) error {
+	if packet.Type < Connect || packet.Type > BinaryAck {
+		return decodeErr
+	}
+
+	switch packet.Type {
+	case Event, BinaryEvent:
+		args, ok := packet.Data.([]interface{})
+		if !ok || len(args) == 0 {
+			return decodeErr
+		}
+		if _, ok := args[0].(string); !ok {
+			return decodeErr
+		}
+	case Ack, BinaryAck:
+		if _, ok := packet.Data.([]interface{}); !ok {
+			return decodeErr
+		}
 	}
 	return nil
 }
@@ -1,53 +1,127 @@
 package sio
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"github.com/adrianmxb/goseio/pkg/debug"
 	"github.com/adrianmxb/goseio/pkg/eio"
 	"github.com/adrianmxb/goseio/pkg/sio/parser"
+	"github.com/adrianmxb/goseio/pkg/tracing"
 	"net/http"
+	"sync"
 )
 
 type Server struct {
-	eio        *eio.Server
-	sockets    map[string]*Socket
-	connected  map[string]*Socket
-	namespaces map[string]*Namespace
+	eio     *eio.Server
+	parser  parser.Parser
+	encoder parser.Encoder
+	adapter func(namespace *Namespace) IAdapter
+	logger  debug.Logger
+	metrics Metrics
+	tracer  tracing.Tracer
+	// connect packet for the main namespace is sent by eio within the handshake.
+	initialConnect bool
+
+	clientsLock    sync.RWMutex
+	clients        map[string]*Client
+	sockets        map[string]*Socket
+	connected      map[string]*Socket
+	namespacesLock sync.RWMutex
+	namespaces     map[string]*Namespace
 }
 
 type ServerOptions struct {
 	path    string
 	origins []string
+
+	// Parser used to encode and decode packets, defaults to parser.JSONParser. The arguments of events are
+	// decoded into the types of encoding/json with every parser, numbers are float64, objects
+	// map[string]interface{}. Binary parsers may add []byte and, like msgpack, time.Time.
+	Parser parser.Parser
+	// Adapter creates the adapter of a namespace, defaults to NewAdapter.
+	// Use NewClusterAdapter to share rooms and broadcasts between multiple nodes.
+	Adapter func(namespace *Namespace) IAdapter
+	// Logger is used by the sio and eio server, with the field logger set to goseio:socket and goseio:engine.
+	// Defaults to debug.New for both names, which logs if enabled by the DEBUG environment variable.
+	Logger debug.Logger
+	// Metrics defaults to discarding everything.
+	Metrics Metrics
+	// Tracer records the spans sio.event and sio.ack as well as the spans of the eio server,
+	// defaults to tracing.Nop. See Socket.TraceContext for the propagation of the trace context.
+	Tracer tracing.Tracer
+	// Dispatch decides which goroutine handles the packets of a client, see eio.Server.Dispatch.
+	// The events and acks of a client are handled in the order they were received in every mode.
+	Dispatch eio.DispatchMode
+	// Workers is the number of goroutines of eio.DispatchPool, defaults to runtime.NumCPU().
+	Workers int
 }
 
-func NewServer() (*Server, error) {
-	var buf bytes.Buffer
-	writer := bufio.NewWriter(&buf)
+func NewServer(opt ServerOptions) (*Server, error) {
+	if opt.Parser == nil {
+		opt.Parser = parser.JSONParser{}
+	}
+	if opt.Adapter == nil {
+		opt.Adapter = func(namespace *Namespace) IAdapter {
+			return NewAdapter(namespace)
+		}
+	}
+	if opt.Metrics == nil {
+		opt.Metrics = nopMetrics{}
+	}
+	if opt.Tracer == nil {
+		opt.Tracer = tracing.Nop
+	}
+	encoder := opt.Parser.NewEncoder()
 
-	err := parser.Encode(parser.Packet{
+	data, isBinary, err := encoder.Encode(parser.Packet{
 		Id:        nil,
 		Type:      parser.Connect,
 		Namespace: "/",
 		Data:      nil,
-	}, writer)
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
+	// the initial packet is sent as text, binary parsers send their connect packet on their own.
+	var buf bytes.Buffer
+	if !isBinary {
+		buf.Write(data)
+	}
+
 	eioSrv, err := eio.NewServer("/socket.io", buf)
 	if err != nil {
 		return nil, err
 	}
+	eioSrv.Logger = debug.Named(opt.Logger, "goseio:engine")
+	if metrics, ok := opt.Metrics.(eio.Metrics); ok {
+		eioSrv.Metrics = metrics
+	}
+	eioSrv.Tracer = opt.Tracer
+	eioSrv.Dispatch = opt.Dispatch
+	eioSrv.Workers = opt.Workers
 
 	srv := &Server{
-		eio:        eioSrv,
-		sockets:    make(map[string]*Socket),
-		connected:  make(map[string]*Socket),
-		namespaces: make(map[string]*Namespace),
+		eio:            eioSrv,
+		parser:         opt.Parser,
+		encoder:        encoder,
+		adapter:        opt.Adapter,
+		logger:         debug.Named(opt.Logger, "goseio:socket"),
+		metrics:        opt.Metrics,
+		tracer:         opt.Tracer,
+		initialConnect: !isBinary,
+		clients:        make(map[string]*Client),
+		sockets:        make(map[string]*Socket),
+		connected:      make(map[string]*Socket),
+		namespaces:     make(map[string]*Namespace),
 	}
 
 	srv.eio.ConnectHandler = srv.HandleConnection
+	srv.eio.MsgHandler = srv.HandleMessage
+
+	// the main namespace always exists.
+	srv.Of("/")
 
 	return srv, nil
 }
@@ -58,14 +132,41 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) HandleConnection(socket *eio.Socket) {
 	client := NewClient(s, socket)
+
+	s.clientsLock.Lock()
+	s.clients[socket.Id] = client
+	s.clientsLock.Unlock()
+
 	client.Connect("/", "")
+
+	// called right away if the socket closed in the meantime.
+	socket.OnClose(func(reason string, err error) {
+		s.clientsLock.Lock()
+		delete(s.clients, socket.Id)
+		s.clientsLock.Unlock()
+		client.onClose(reason)
+	})
+}
+
+func (s *Server) HandleMessage(socket *eio.Socket, data []byte, isBinary bool) {
+	s.clientsLock.RLock()
+	client, ok := s.clients[socket.Id]
+	s.clientsLock.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	client.OnData(data, isBinary)
 }
 
 func (s *Server) Of(name string) *Namespace {
-	if name[0] != '/' {
+	if name == "" || name[0] != '/' {
 		name = "/" + name
 	}
 
+	defer s.namespacesLock.Unlock()
+	s.namespacesLock.Lock()
 	namespace, ok := s.namespaces[name]
 	if !ok {
 		namespace = NewNamespace(s, name)
@@ -73,3 +174,48 @@ func (s *Server) Of(name string) *Namespace {
 	}
 	return namespace
 }
+
+// Shutdown sends a disconnect packet to every socket and disconnects it with the reason "server shutting down",
+// shuts down the eio server and closes the adapters of all namespaces, see eio.Server.Shutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.clientsLock.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.clientsLock.RUnlock()
+
+	// the eio server flushes the disconnect packets before closing the connections.
+	for _, client := range clients {
+		client.disconnect("server shutting down")
+	}
+
+	err := s.eio.Shutdown(ctx)
+
+	s.namespacesLock.RLock()
+	defer s.namespacesLock.RUnlock()
+	for _, namespace := range s.namespaces {
+		namespace.adapter.Close()
+	}
+	return err
+}
+
+// Engine returns the underlying eio server.
+func (s *Server) Engine() *eio.Server {
+	return s.eio
+}
+
+// Namespaces returns every namespace created with Of.
+func (s *Server) Namespaces() []*Namespace {
+	defer s.namespacesLock.RUnlock()
+	s.namespacesLock.RLock()
+	namespaces := make([]*Namespace, 0, len(s.namespaces))
+	for _, namespace := range s.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+func (s *Server) newAdapter(namespace *Namespace) IAdapter {
+	return s.adapter(namespace)
+}
@@ -0,0 +1,63 @@
+package sio
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	reasons := make(chan interface{}, 1)
+	srv, ts := newTestServer(t, func(socket *Socket) {
+		socket.On("disconnect", func(socket *Socket, args []interface{}, ack AckFunc) {
+			reasons <- args[0]
+		})
+	})
+	defer ts.Close()
+
+	c := dial(t, ts)
+	defer c.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if reason := <-reasons; reason != "server shutting down" {
+		t.Fatalf("unexpected disconnect reason %v", reason)
+	}
+	if msg := c.read(); msg != "41" {
+		t.Fatalf("expected disconnect packet, got %q", msg)
+	}
+	if msg := c.read(); msg != "1" {
+		t.Fatalf("expected close packet, got %q", msg)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	reasons := make(chan interface{}, 1)
+	srv, ts := newTestServer(t, func(socket *Socket) {
+		socket.On("disconnect", func(socket *Socket, args []interface{}, ack AckFunc) {
+			reasons <- args[0]
+		})
+	})
+	defer ts.Close()
+
+	c := dial(t, ts)
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.close()
+
+	select {
+	case reason := <-reasons:
+		if reason != "transport close" {
+			t.Fatalf("unexpected disconnect reason %v", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("socket wasn't disconnected")
+	}
+	if n := srv.Of("/").SocketCount(); n != 0 {
+		t.Fatalf("%d sockets left in the namespace", n)
+	}
+}
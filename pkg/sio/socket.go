@@ -1,11 +1,57 @@
 package sio
 
+import (
+	"context"
+	"errors"
+	"github.com/adrianmxb/goseio/pkg/sio/parser"
+	"github.com/adrianmxb/goseio/pkg/tracing"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AckFunc is used to acknowledge an event. Passed as the last argument of Emit it gets called with the
+// arguments the client acknowledged the event with.
+type AckFunc func(args ...interface{})
+
+// EventHandlerFunc handles an incoming event. ack is nil if the client didn't request an acknowledgement.
+type EventHandlerFunc func(socket *Socket, args []interface{}, ack AckFunc)
+
+// SocketMiddleware gets called for every incoming event packet before the event handlers are run.
+// The packet may be altered. Calling next(nil) passes the packet on, calling next with an error
+// rejects the packet and sends the error to the client as "error" event.
+// If next is never called, the packet is dropped.
+type SocketMiddleware func(packet *parser.Packet, next func(err error))
+
+var reservedEvent = errors.New("event name is reserved")
+
+var errDisconnected = errors.New("socket disconnected")
+
+var reservedEvents = map[string]bool{
+	"connect":        true,
+	"disconnect":     true,
+	"disconnecting":  true,
+	"error":          true,
+	"newListener":    true,
+	"removeListener": true,
+}
+
 type Socket struct {
 	namespace *Namespace
 	adapter   IAdapter
 	id        string
 	client    *Client
-	rooms     map[string]struct{}
+	ctx       context.Context
+
+	handlersLock sync.RWMutex
+	handlers     map[string][]eventHandler
+	middlewares  []SocketMiddleware
+
+	acksLock sync.Mutex
+	acks     map[int]pendingAck
+
+	closeOnce sync.Once
 }
 
 func NewSocket(namespace *Namespace, client *Client, query string) *Socket {
@@ -14,36 +60,356 @@ func NewSocket(namespace *Namespace, client *Client, query string) *Socket {
 		id = namespace.name + "#" + id
 	}
 
+	ctx := client.conn.TraceContext()
+	if values, err := url.ParseQuery(query); err == nil {
+		ctx = tracing.Extract(ctx, values.Get("traceparent"))
+	}
+
 	socket := &Socket{
 		namespace: namespace,
+		adapter:   namespace.adapter,
 		client:    client,
 		id:        id,
-		rooms:     make(map[string]struct{}),
+		ctx:       ctx,
+		handlers:  make(map[string][]eventHandler),
+		acks:      make(map[int]pendingAck),
 	}
 
 	return socket
 }
 
+func (s *Socket) Id() string {
+	return s.id
+}
+
+func (s *Socket) Namespace() *Namespace {
+	return s.namespace
+}
+
+// TraceContext returns the parent context of the spans of the socket. It carries the traceparent the client
+// sent with the namespace query, e.g. io("/chat?traceparent=00-…"), or else the eio.handshake span.
+// A single event is traced with a different parent if the client appends {"traceparent": "00-…"} as its last
+// argument, which is removed before the handlers run if ServerOptions.Tracer is set.
+func (s *Socket) TraceContext() context.Context {
+	return s.ctx
+}
+
 func (s *Socket) onConnect() {
+	s.namespace.server.logger.Debug("connected", "sid", s.id, "namespace", s.namespace.name)
+	s.namespace.server.metrics.Connect(s.namespace.name)
 	s.Join(s.id)
+
+	// the connect packet for the main namespace may already be sent with the handshake.
+	if s.namespace.name != "/" || !s.namespace.server.initialConnect {
+		s.packet(parser.Packet{
+			Type:      parser.Connect,
+			Namespace: s.namespace.name,
+		})
+	}
 }
 
-func (s *Socket) Join(rooms ...string) {
-	s.adapter.Add(s.id, rooms...)
-	for _, room := range rooms {
-		if _, ok := s.rooms[room]; ok {
-			continue
+// On registers a handler for the given event.
+func (s *Socket) On(event string, handler EventHandlerFunc) {
+	s.addHandler(event, handler.eventHandler())
+}
+
+// OnTyped registers a function with typed parameters like func(s *sio.Socket, msg ChatMsg, ack func(Reply))
+// for the given event, see newEventHandler. It returns an error if handler has an unsupported signature.
+func (s *Socket) OnTyped(event string, handler interface{}) error {
+	h, err := newEventHandler(event, handler)
+	if err != nil {
+		return err
+	}
+	s.addHandler(event, h)
+	return nil
+}
+
+func (s *Socket) addHandler(event string, h eventHandler) {
+	defer s.handlersLock.Unlock()
+	s.handlersLock.Lock()
+	s.handlers[event] = append(s.handlers[event], h)
+}
+
+// Use registers a middleware that is run for every incoming event packet.
+func (s *Socket) Use(middleware SocketMiddleware) {
+	defer s.handlersLock.Unlock()
+	s.handlersLock.Lock()
+	s.middlewares = append(s.middlewares, middleware)
+}
+
+type pendingAck struct {
+	fn   AckFunc
+	sent time.Time
+	span tracing.Span
+}
+
+// Emit sends an event to the client. If the last argument is an AckFunc, it gets called once the client
+// acknowledged the event.
+func (s *Socket) Emit(event string, args ...interface{}) error {
+	return s.emit(FlagCompress, event, args)
+}
+
+// Compress returns an emitter sending events to this socket with the given compression setting.
+func (s *Socket) Compress(compress bool) *SocketEmitter {
+	e := &SocketEmitter{socket: s, flags: FlagCompress}
+	return e.Compress(compress)
+}
+
+func (s *Socket) emit(flags int, event string, args []interface{}) error {
+	if reservedEvents[event] {
+		return reservedEvent
+	}
+
+	pack := parser.Packet{
+		Type:      parser.Event,
+		Namespace: s.namespace.name,
+	}
+
+	if len(args) > 0 {
+		if ack, ok := args[len(args)-1].(AckFunc); ok {
+			args = args[:len(args)-1]
+			id := int(atomic.AddUint64(&s.namespace.ackId, 1) - 1)
+			_, span := s.namespace.server.tracer.Start(s.ctx, "sio.ack")
+			span.SetAttribute("sio.sid", s.id)
+			span.SetAttribute("sio.namespace", s.namespace.name)
+			span.SetAttribute("sio.event", event)
+			span.SetAttribute("sio.ack_id", id)
+			s.acksLock.Lock()
+			s.acks[id] = pendingAck{fn: ack, sent: time.Now(), span: span}
+			s.acksLock.Unlock()
+			pack.Id = &id
 		}
-		s.rooms[room] = struct{}{}
 	}
+
+	pack.Data = append([]interface{}{event}, args...)
+	return s.client.packet(pack, flags)
+}
+
+func (s *Socket) packet(pack parser.Packet) error {
+	return s.client.packet(pack, FlagCompress)
+}
+
+// SocketEmitter emits events to a single socket with non default flags.
+type SocketEmitter struct {
+	socket *Socket
+	flags  int
+}
+
+// Compress sets whether the event may be compressed.
+func (e *SocketEmitter) Compress(compress bool) *SocketEmitter {
+	if compress {
+		return &SocketEmitter{socket: e.socket, flags: e.flags | FlagCompress}
+	}
+	return &SocketEmitter{socket: e.socket, flags: e.flags &^ FlagCompress}
+}
+
+// Emit sends an event like Socket.Emit.
+func (e *SocketEmitter) Emit(event string, args ...interface{}) error {
+	return e.socket.emit(e.flags, event, args)
+}
+
+func (s *Socket) error(data interface{}) {
+	s.packet(parser.Packet{
+		Type:      parser.Error,
+		Namespace: s.namespace.name,
+		Data:      data,
+	})
+}
+
+func (s *Socket) onPacket(pack *parser.Packet) {
+	switch pack.Type {
+	case parser.Event, parser.BinaryEvent:
+		s.onEvent(pack)
+	case parser.Ack, parser.BinaryAck:
+		s.onAck(pack)
+	case parser.Disconnect:
+		s.onDisconnect()
+	case parser.Error:
+		s.onError(pack)
+	}
+}
+
+func (s *Socket) onEvent(pack *parser.Packet) {
+	s.handlersLock.RLock()
+	middlewares := s.middlewares
+	s.handlersLock.RUnlock()
+
+	s.runMiddlewares(middlewares, pack, func() {
+		args, ok := pack.Data.([]interface{})
+		if !ok || len(args) == 0 {
+			return
+		}
+		event, ok := args[0].(string)
+		if !ok {
+			return
+		}
+
+		args = args[1:]
+		ctx := s.ctx
+		// without a tracer the argument belongs to the application.
+		if len(args) > 0 && s.namespace.server.tracer != tracing.Nop {
+			if traceParent, ok := traceArg(args[len(args)-1]); ok {
+				ctx = tracing.Extract(ctx, traceParent)
+				args = args[:len(args)-1]
+			}
+		}
+
+		ctx, span := s.namespace.server.tracer.Start(ctx, "sio.event")
+		span.SetAttribute("sio.sid", s.id)
+		span.SetAttribute("sio.namespace", s.namespace.name)
+		span.SetAttribute("sio.event", event)
+		defer span.End()
+
+		var ack AckFunc
+		if pack.Id != nil {
+			span.SetAttribute("sio.ack_id", *pack.Id)
+			ack = s.ack(*pack.Id)
+		}
+		s.dispatch(ctx, event, args, ack)
+	})
+}
+
+// traceArg returns the traceparent of an argument like {"traceparent": "00-…"}.
+func traceArg(arg interface{}) (string, bool) {
+	m, ok := arg.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	traceParent, ok := m["traceparent"].(string)
+	return traceParent, ok
+}
+
+func (s *Socket) runMiddlewares(middlewares []SocketMiddleware, pack *parser.Packet, done func()) {
+	if len(middlewares) == 0 {
+		done()
+		return
+	}
+
+	var once sync.Once
+	middlewares[0](pack, func(err error) {
+		once.Do(func() {
+			if err != nil {
+				s.error(err.Error())
+				return
+			}
+			s.runMiddlewares(middlewares[1:], pack, done)
+		})
+	})
+}
+
+func (s *Socket) dispatch(ctx context.Context, event string, args []interface{}, ack AckFunc) {
+	s.handlersLock.RLock()
+	handlers := s.handlers[event]
+	s.handlersLock.RUnlock()
+
+	for _, handler := range handlers {
+		handler(ctx, s, args, ack)
+	}
+}
+
+// ack creates the callback that acknowledges the incoming event with the given id, it only sends once.
+func (s *Socket) ack(id int) AckFunc {
+	var once sync.Once
+	return func(args ...interface{}) {
+		once.Do(func() {
+			if args == nil {
+				args = []interface{}{}
+			}
+			s.packet(parser.Packet{
+				Id:        &id,
+				Type:      parser.Ack,
+				Namespace: s.namespace.name,
+				Data:      args,
+			})
+		})
+	}
+}
+
+func (s *Socket) onAck(pack *parser.Packet) {
+	if pack.Id == nil {
+		return
+	}
+
+	s.acksLock.Lock()
+	ack, ok := s.acks[*pack.Id]
+	delete(s.acks, *pack.Id)
+	s.acksLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	s.namespace.server.metrics.Ack(s.namespace.name, time.Since(ack.sent))
+	ack.span.End()
+	args, _ := pack.Data.([]interface{})
+	ack.fn(args...)
+}
+
+func (s *Socket) onError(pack *parser.Packet) {
+	s.dispatch(s.ctx, "error", []interface{}{pack.Data}, nil)
+}
+
+func (s *Socket) onDisconnect() {
+	s.onClose("client namespace disconnect")
+}
+
+func (s *Socket) onClose(reason string) {
+	s.closeOnce.Do(func() {
+		s.namespace.server.logger.Debug("disconnected", "sid", s.id, "namespace", s.namespace.name,
+			"reason", reason)
+		s.namespace.server.metrics.Disconnect(s.namespace.name, reason)
+		s.LeaveAll()
+		s.namespace.remove(s)
+		s.client.remove(s)
+
+		// the client won't acknowledge anymore.
+		s.acksLock.Lock()
+		acks := s.acks
+		s.acks = make(map[int]pendingAck)
+		s.acksLock.Unlock()
+		for _, ack := range acks {
+			ack.span.RecordError(errDisconnected)
+			ack.span.End()
+		}
+
+		s.dispatch(s.ctx, "disconnect", []interface{}{reason}, nil)
+	})
+}
+
+// Disconnect disconnects the socket from its namespace. If close is set, the underlying connection is
+// closed as well.
+func (s *Socket) Disconnect(close bool) {
+	if close {
+		s.client.close()
+		s.onClose("server namespace disconnect")
+		return
+	}
+
+	s.packet(parser.Packet{
+		Type:      parser.Disconnect,
+		Namespace: s.namespace.name,
+	})
+	s.onClose("server namespace disconnect")
+}
+
+// To broadcasts to the sockets in the given rooms, excluding this socket.
+func (s *Socket) To(rooms ...string) *BroadcastOperator {
+	return newBroadcastOperator(s.namespace).Except(s.id).To(rooms...)
+}
+
+// Rooms returns the rooms the socket is in.
+func (s *Socket) Rooms() []string {
+	return s.adapter.GetRoomsOf(s.id)
+}
+
+func (s *Socket) Join(rooms ...string) {
+	s.adapter.Add(s.id, rooms...)
 }
 
 func (s *Socket) Leave(room string) {
 	s.adapter.Del(s.id, room)
-	delete(s.rooms, room)
 }
 
 func (s *Socket) LeaveAll() {
 	s.adapter.DelAll(s.id)
-	s.rooms = make(map[string]struct{})
 }
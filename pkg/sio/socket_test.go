@@ -0,0 +1,205 @@
+package sio
+
+import (
+	"errors"
+	"github.com/adrianmxb/goseio/pkg/sio/parser"
+	"github.com/gorilla/websocket"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient speaks engine.io v3 / socket.io v2 over a websocket.
+type testClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dial(t *testing.T, srv *httptest.Server) *testClient {
+	return dialQuery(t, srv, "")
+}
+
+// dialQuery connects like dial, appending query to the handshake url.
+func dialQuery(t *testing.T, srv *httptest.Server, query string) *testClient {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/socket.io/?EIO=3&transport=websocket" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testClient{t: t, conn: conn}
+	if msg := c.read(); msg[0] != '0' {
+		t.Fatalf("expected open packet, got %q", msg)
+	}
+	if msg := c.read(); msg != "40" {
+		t.Fatalf("expected connect packet, got %q", msg)
+	}
+	return c
+}
+
+func (c *testClient) read() string {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return string(msg)
+}
+
+// readMessage returns the next engine.io message, skipping pings and noops.
+func (c *testClient) readMessage() string {
+	for {
+		if msg := c.read(); msg[0] == '4' {
+			return msg[1:]
+		}
+	}
+}
+
+func (c *testClient) send(msg string) {
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte("4"+msg)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) close() {
+	c.conn.Close()
+}
+
+func newTestServer(t *testing.T, onConnect func(socket *Socket)) (*Server, *httptest.Server) {
+	return newTestServerWithOptions(t, ServerOptions{}, onConnect)
+}
+
+func newTestServerWithOptions(t *testing.T, opt ServerOptions, onConnect func(socket *Socket)) (*Server, *httptest.Server) {
+	srv, err := NewServer(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Of("/").OnConnect = func(socket *Socket, next NamespaceMiddleware) NamespaceMiddleware {
+		onConnect(socket)
+		return nil
+	}
+	return srv, httptest.NewServer(srv)
+}
+
+func TestSocketMiddleware(t *testing.T) {
+	_, ts := newTestServer(t, func(socket *Socket) {
+		socket.Use(func(packet *parser.Packet, next func(err error)) {
+			args := packet.Data.([]interface{})
+			switch args[0] {
+			case "forbidden":
+				next(errors.New("not authorized"))
+			case "dropped":
+			default:
+				packet.Data = append(args, "checked")
+				next(nil)
+			}
+		})
+		echo := func(socket *Socket, args []interface{}, ack AckFunc) {
+			ack(args...)
+		}
+		socket.On("forbidden", echo)
+		socket.On("dropped", echo)
+		socket.On("echo", echo)
+	})
+	defer ts.Close()
+
+	c := dial(t, ts)
+	defer c.close()
+
+	c.send(`21["dropped"]`)
+	c.send(`22["forbidden"]`)
+	if msg := c.readMessage(); msg != `4"not authorized"` {
+		t.Fatalf("expected error packet, got %q", msg)
+	}
+
+	c.send(`23["echo","hi"]`)
+	if msg := c.readMessage(); msg != `33["hi","checked"]` {
+		t.Fatalf("expected ack with altered args, got %q", msg)
+	}
+}
+
+type chatMsg struct {
+	User string `json:"user"`
+	Text string `json:"text"`
+}
+
+type chatReply struct {
+	Length int `json:"length"`
+}
+
+func TestTypedHandler(t *testing.T) {
+	_, ts := newTestServer(t, func(socket *Socket) {
+		err := socket.OnTyped("chat", func(s *Socket, msg chatMsg, times int, ack func(chatReply)) {
+			ack(chatReply{Length: len(msg.User+msg.Text) * times})
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	defer ts.Close()
+
+	c := dial(t, ts)
+	defer c.close()
+
+	c.send(`21["chat",{"user":"ab","text":"cde"},2]`)
+	if msg := c.readMessage(); msg != `31[{"length":10}]` {
+		t.Fatalf("expected typed ack, got %q", msg)
+	}
+
+	c.send(`22["chat","not an object"]`)
+	if msg := c.readMessage(); !strings.HasPrefix(msg, `4"event \"chat\": argument 0:`) {
+		t.Fatalf("expected argument error, got %q", msg)
+	}
+}
+
+func TestInvalidHandler(t *testing.T) {
+	for _, handler := range []interface{}{nil, "chat", func(msg chatMsg) {}, func(s *Socket) error { return nil }} {
+		if _, err := newEventHandler("chat", handler); err == nil {
+			t.Errorf("expected an error for %T", handler)
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	_, ts := newTestServer(t, func(socket *Socket) {
+		conn := NewConn(socket, "stream")
+		go io.Copy(conn, conn)
+	})
+	defer ts.Close()
+
+	c := dial(t, ts)
+	defer c.close()
+
+	// the json parser sends binary data base64 encoded.
+	c.send(`2["stream","aGVsbG8="]`)
+	if msg := c.readMessage(); msg != `2["stream","aGVsbG8="]` {
+		t.Fatalf("unexpected echo %q", msg)
+	}
+}
+
+func TestConnInvalidData(t *testing.T) {
+	conns := make(chan net.Conn, 1)
+	_, ts := newTestServer(t, func(socket *Socket) {
+		conns <- NewConn(socket, "stream")
+	})
+	defer ts.Close()
+
+	c := dial(t, ts)
+	defer c.close()
+	conn := <-conns
+
+	// dropping the data would corrupt the stream.
+	c.send(`2["stream","aGVsbG8="]`)
+	c.send(`2["stream","not base64"]`)
+	if msg := c.read(); msg != "41" {
+		t.Fatalf("expected disconnect packet, got %q", msg)
+	}
+	data, err := ioutil.ReadAll(conn)
+	if string(data) != "hello" || err != invalidConnDataErr {
+		t.Fatalf("read %q, %v", data, err)
+	}
+}
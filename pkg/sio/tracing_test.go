@@ -0,0 +1,90 @@
+package sio
+
+import (
+	"context"
+	"github.com/adrianmxb/goseio/pkg/tracing"
+	"testing"
+	"time"
+)
+
+// waitSpan waits until the span called name was exported.
+func waitSpan(t *testing.T, exporter *tracing.InMemoryExporter, name string) tracing.SpanData {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, span := range exporter.Spans() {
+			if span.Name == name {
+				return span
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("span %s wasn't exported", name)
+	return tracing.SpanData{}
+}
+
+func TestTracing(t *testing.T) {
+	const handshakeParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	const eventParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	exporter := tracing.NewInMemoryExporter()
+	handled := make(chan tracing.SpanContext, 1)
+	_, ts := newTestServerWithOptions(t, ServerOptions{Tracer: tracing.NewTracer(exporter)}, func(socket *Socket) {
+		socket.OnTyped("chat", func(s *Socket, ctx context.Context, msg string, extra interface{}, ack func(string)) {
+			if msg != "hi" || extra != nil {
+				t.Errorf("unexpected arguments %v %v", msg, extra)
+			}
+			sc, _ := tracing.SpanContextFromContext(ctx)
+			handled <- sc
+			ack("ok")
+		})
+		socket.Emit("ping", AckFunc(func(args ...interface{}) {}))
+	})
+	defer ts.Close()
+
+	c := dialQuery(t, ts, "&traceparent="+handshakeParent)
+	defer c.close()
+
+	if msg := c.readMessage(); msg != `20["ping"]` {
+		t.Fatalf("expected ping, got %q", msg)
+	}
+	c.send(`30["pong"]`)
+	c.send(`21["chat","hi",{"traceparent":"` + eventParent + `"}]`)
+	if msg := c.readMessage(); msg != `31["ok"]` {
+		t.Fatalf("expected ack, got %q", msg)
+	}
+
+	handshake := waitSpan(t, exporter, "eio.handshake")
+	if handshake.Parent.String() != handshakeParent {
+		t.Fatalf("handshake has parent %s", handshake.Parent)
+	}
+	ack := waitSpan(t, exporter, "sio.ack")
+	if ack.Parent != handshake.SpanContext || ack.Attributes["sio.event"] != "ping" {
+		t.Fatalf("unexpected ack span %+v", ack)
+	}
+	event := waitSpan(t, exporter, "sio.event")
+	if event.Parent.String() != eventParent || event.Attributes["sio.event"] != "chat" {
+		t.Fatalf("unexpected event span %+v", event)
+	}
+	if sc := <-handled; sc != event.SpanContext {
+		t.Fatalf("handler got span %s, expected %s", sc, event.SpanContext)
+	}
+}
+
+func TestTraceArgWithoutTracer(t *testing.T) {
+	received := make(chan interface{}, 1)
+	_, ts := newTestServer(t, func(socket *Socket) {
+		socket.OnTyped("chat", func(s *Socket, msg string, extra interface{}) {
+			received <- extra
+		})
+	})
+	defer ts.Close()
+
+	c := dial(t, ts)
+	defer c.close()
+
+	c.send(`2["chat","hi",{"traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}]`)
+	extra, ok := (<-received).(map[string]interface{})
+	if !ok || extra["traceparent"] == nil {
+		t.Fatalf("expected the traceparent argument, got %v", extra)
+	}
+}
@@ -0,0 +1,331 @@
+// Package stream implements a net.Conn on top of a message based connection, see eio.NewConn and sio.NewConn.
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxMessageSize is the maximal size of the messages a Write is split into.
+	MaxMessageSize = 64 << 10
+	// Window is the number of bytes that may be queued but not flushed yet, Write blocks once it is reached.
+	Window = 256 << 10
+	// ReadBuffer is the default number of received messages buffered until Push blocks.
+	ReadBuffer = 64
+)
+
+// ErrReadBufferFull is returned by Read once a message couldn't be pushed because the reader fell too far behind,
+// see CloseReadError.
+var ErrReadBufferFull = errors.New("read buffer full")
+
+type Options struct {
+	// Send queues a message and calls done once it was flushed or dropped.
+	Send func(data []byte, done func(err error)) error
+	// Close closes the underlying connection.
+	Close func() error
+	// ReadBuffer defaults to ReadBuffer.
+	ReadBuffer int
+	Local      net.Addr
+	Remote     net.Addr
+}
+
+// Addr is the address of a stream.
+type Addr struct {
+	Net     string
+	Address string
+}
+
+func (a Addr) Network() string { return a.Net }
+func (a Addr) String() string  { return a.Address }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Conn is a net.Conn sending every Write as messages and reading the messages passed to Push.
+type Conn struct {
+	opt Options
+
+	in       chan []byte
+	eof      chan struct{}
+	eofOnce  sync.Once
+	eofErr   error
+	readLock sync.Mutex
+	buf      []byte
+
+	writeLock sync.Mutex
+	lock      sync.Mutex
+	pending   int
+	err       error
+	space     chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+}
+
+func New(opt Options) *Conn {
+	if opt.ReadBuffer <= 0 {
+		opt.ReadBuffer = ReadBuffer
+	}
+	return &Conn{
+		opt:           opt,
+		in:            make(chan []byte, opt.ReadBuffer),
+		eof:           make(chan struct{}),
+		space:         make(chan struct{}, 1),
+		closed:        make(chan struct{}),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+	}
+}
+
+// Push passes a received message to Read. While ReadBuffer messages are waiting to be read it blocks, which slows
+// down the sender, until ctx is done. A message that couldn't be pushed is lost, the caller should end the read
+// side with CloseReadError then.
+func (c *Conn) Push(ctx context.Context, data []byte) error {
+	select {
+	case <-c.closed:
+		return io.ErrClosedPipe
+	case <-c.eof:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	select {
+	case c.in <- data:
+		return nil
+	case <-c.closed:
+		return io.ErrClosedPipe
+	case <-c.eof:
+		return io.ErrClosedPipe
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CloseRead marks the end of the received messages, once they were read Read returns io.EOF.
+func (c *Conn) CloseRead() {
+	c.closeRead(io.EOF)
+}
+
+// CloseReadError marks the end of the received messages like CloseRead, but Read returns err instead of io.EOF,
+// e.g. because the stream is missing a message.
+func (c *Conn) CloseReadError(err error) {
+	c.closeRead(err)
+}
+
+// closeRead marks the end of the received messages, once they were read Read returns err.
+func (c *Conn) closeRead(err error) {
+	c.eofOnce.Do(func() {
+		c.eofErr = err
+		close(c.eof)
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	defer c.readLock.Unlock()
+	c.readLock.Lock()
+
+	for len(c.buf) == 0 {
+		select {
+		case <-c.closed:
+			return 0, io.ErrClosedPipe
+		case <-c.readDeadline.wait():
+			return 0, timeoutError{}
+		case c.buf = <-c.in:
+			continue
+		default:
+		}
+
+		select {
+		case <-c.closed:
+			return 0, io.ErrClosedPipe
+		case <-c.readDeadline.wait():
+			return 0, timeoutError{}
+		case c.buf = <-c.in:
+		case <-c.eof:
+			// messages pushed before the end are read first.
+			select {
+			case c.buf = <-c.in:
+			default:
+				return 0, c.eofErr
+			}
+		}
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write sends b as messages of at most MaxMessageSize bytes.
+func (c *Conn) Write(b []byte) (int, error) {
+	defer c.writeLock.Unlock()
+	c.writeLock.Lock()
+
+	n := 0
+	for len(b) > 0 {
+		size := len(b)
+		if size > MaxMessageSize {
+			size = MaxMessageSize
+		}
+		if err := c.waitSpace(); err != nil {
+			return n, err
+		}
+
+		data := append([]byte(nil), b[:size]...)
+		c.lock.Lock()
+		c.pending += size
+		c.lock.Unlock()
+		if err := c.opt.Send(data, c.sent(size)); err != nil {
+			return n, err
+		}
+		n += size
+		b = b[size:]
+	}
+	return n, nil
+}
+
+// waitSpace waits until less than Window bytes are pending.
+func (c *Conn) waitSpace() error {
+	for {
+		c.lock.Lock()
+		pending, err := c.pending, c.err
+		c.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		if pending < Window {
+			return nil
+		}
+
+		select {
+		case <-c.closed:
+			return io.ErrClosedPipe
+		case <-c.eof:
+			return io.ErrClosedPipe
+		case <-c.writeDeadline.wait():
+			return timeoutError{}
+		case <-c.space:
+		}
+	}
+}
+
+// sent returns the callback of a message of size bytes, the first error is returned by the following writes.
+func (c *Conn) sent(size int) func(err error) {
+	return func(err error) {
+		c.lock.Lock()
+		c.pending -= size
+		if err != nil && c.err == nil {
+			c.err = err
+		}
+		c.lock.Unlock()
+
+		select {
+		case c.space <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *Conn) Close() error {
+	err := io.ErrClosedPipe
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = nil
+		if c.opt.Close != nil {
+			err = c.opt.Close()
+		}
+	})
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.opt.Local
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.opt.Remote
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// deadline provides a channel that is closed once the deadline passed.
+type deadline struct {
+	lock   sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	defer d.lock.Unlock()
+	d.lock.Lock()
+
+	// a timer that couldn't be stopped is about to close cancel.
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := false
+	select {
+	case <-d.cancel:
+		closed = true
+	default:
+	}
+
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	defer d.lock.Unlock()
+	d.lock.Lock()
+	return d.cancel
+}
@@ -0,0 +1,137 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadEOF(t *testing.T) {
+	c := New(Options{})
+	c.Push(context.Background(), []byte("hello "))
+	c.Push(context.Background(), []byte("world"))
+	c.CloseRead()
+
+	b := make([]byte, 3)
+	var read bytes.Buffer
+	for {
+		n, err := c.Read(b)
+		read.Write(b[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if read.String() != "hello world" {
+		t.Fatalf("read %q", read.String())
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	c := New(Options{})
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := c.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	// clearing the deadline allows reading again.
+	c.SetReadDeadline(time.Time{})
+	c.Push(context.Background(), []byte("x"))
+	if n, err := c.Read(make([]byte, 1)); n != 1 || err != nil {
+		t.Fatalf("read %d, %v", n, err)
+	}
+}
+
+func TestWriteWindow(t *testing.T) {
+	var done []func(err error)
+	var sizes []int
+	c := New(Options{
+		Send: func(data []byte, fn func(err error)) error {
+			sizes = append(sizes, len(data))
+			done = append(done, fn)
+			return nil
+		},
+	})
+
+	if n, err := c.Write(make([]byte, Window)); n != Window || err != nil {
+		t.Fatalf("wrote %d, %v", n, err)
+	}
+	if len(sizes) != Window/MaxMessageSize || sizes[0] != MaxMessageSize {
+		t.Fatalf("unexpected messages %v", sizes)
+	}
+
+	// the window is full until a message was flushed.
+
+	c.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := c.Write([]byte("x")); err == nil || !err.(net.Error).Timeout() {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	done[0](nil)
+	c.SetWriteDeadline(time.Time{})
+	if _, err := c.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	// failed messages break the conn.
+	done[1](io.ErrUnexpectedEOF)
+	if _, err := c.Write([]byte("x")); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected the send error, got %v", err)
+	}
+}
+
+func TestReadBufferFull(t *testing.T) {
+	c := New(Options{ReadBuffer: 2})
+	for _, msg := range []string{"a", "b"} {
+		if err := c.Push(context.Background(), []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Push blocks until the reader made room.
+	pushed := make(chan error, 1)
+	go func() {
+		pushed <- c.Push(context.Background(), []byte("c"))
+	}()
+	select {
+	case err := <-pushed:
+		t.Fatalf("push didn't block: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	b := make([]byte, 1)
+	if n, err := c.Read(b); n != 1 || err != nil || b[0] != 'a' {
+		t.Fatalf("read %q, %v", b[:n], err)
+	}
+	if err := <-pushed; err != nil {
+		t.Fatal(err)
+	}
+
+	// or until ctx is done, the caller ends the read side then.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Push(ctx, []byte("d")); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	c.CloseReadError(ErrReadBufferFull)
+
+	var read bytes.Buffer
+	for {
+		n, err := c.Read(b)
+		read.Write(b[:n])
+		if err == ErrReadBufferFull {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if read.String() != "bc" {
+		t.Fatalf("read %q", read.String())
+	}
+}
@@ -0,0 +1,90 @@
+// Package otel adapts an OpenTelemetry tracer to tracing.Tracer, e.g. to export the spans of eio and sio:
+//
+//	server, err := sio.NewServer(sio.ServerOptions{Tracer: otel.NewTracer(provider.Tracer("goseio"))})
+//
+// It is a module of its own, so goseio doesn't depend on OpenTelemetry.
+package otel
+
+import (
+	"context"
+	"fmt"
+	"github.com/adrianmxb/goseio/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"math"
+)
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a tracer starting its spans with tracer. The remote span set by tracing.ContextWithRemote,
+// e.g. the traceparent of a client, becomes the parent of the next span.
+func NewTracer(t trace.Tracer) tracing.Tracer {
+	return tracer{tracer: t}
+}
+
+func (t tracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	if remote, ok := tracing.RemoteFromContext(ctx); ok {
+		ctx = trace.ContextWithRemoteSpanContext(tracing.ContextWithoutRemote(ctx), trace.NewSpanContext(
+			trace.SpanContextConfig{
+				TraceID:    remote.TraceID,
+				SpanID:     remote.SpanID,
+				TraceFlags: trace.TraceFlags(remote.Flags),
+				Remote:     true,
+			}))
+	}
+	ctx, s := t.tracer.Start(ctx, name)
+	return ctx, span{span: s}
+}
+
+type span struct {
+	span trace.Span
+}
+
+func (s span) SetAttribute(key string, value interface{}) {
+	s.span.SetAttributes(attributeOf(key, value))
+}
+
+// RecordError records err as event and marks the span as failed.
+func (s span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) End() {
+	s.span.End()
+}
+
+// attributeOf converts value to the attribute type closest to it, values without one are formatted by fmt.
+func attributeOf(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int32:
+		return attribute.Int64(key, int64(v))
+	case int64:
+		return attribute.Int64(key, v)
+	case uint32:
+		return attribute.Int64(key, int64(v))
+	case uint64:
+		if v <= math.MaxInt64 {
+			return attribute.Int64(key, int64(v))
+		}
+	case float32:
+		return attribute.Float64(key, float64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
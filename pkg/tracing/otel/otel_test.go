@@ -0,0 +1,63 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"github.com/adrianmxb/goseio/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+	tracer := NewTracer(provider.Tracer("goseio"))
+
+	remote, _ := tracing.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, parent := tracer.Start(tracing.Extract(context.Background(), remote.String()), "parent")
+	if _, ok := tracing.RemoteFromContext(ctx); ok {
+		t.Fatal("the remote span is still set after it became the parent")
+	}
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("sio.event", "chat")
+	child.SetAttribute("sio.ack_id", 7)
+	child.SetAttribute("other", struct{ A int }{1})
+	child.RecordError(errors.New("failed"))
+	child.End()
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if p.Name != "parent" || p.Parent.TraceID() != remote.TraceID || p.Parent.SpanID() != remote.SpanID ||
+		!p.Parent.IsRemote() {
+		t.Fatalf("unexpected parent span %+v", p)
+	}
+	if c.Name != "child" || c.Parent.SpanID() != p.SpanContext.SpanID() ||
+		c.SpanContext.TraceID() != remote.TraceID {
+		t.Fatalf("unexpected child span %+v", c)
+	}
+
+	expected := []attribute.KeyValue{
+		attribute.String("sio.event", "chat"),
+		attribute.Int("sio.ack_id", 7),
+		attribute.String("other", "{1}"),
+	}
+	if len(c.Attributes) != len(expected) {
+		t.Fatalf("expected attributes %v, got %v", expected, c.Attributes)
+	}
+	for i, attr := range expected {
+		if c.Attributes[i] != attr {
+			t.Errorf("expected attribute %v, got %v", attr, c.Attributes[i])
+		}
+	}
+	if c.Status.Code != codes.Error || len(c.Events) != 1 {
+		t.Fatalf("expected the error to be recorded, got %+v %v", c.Status, c.Events)
+	}
+}
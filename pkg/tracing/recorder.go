@@ -0,0 +1,142 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// SpanData is an ended span.
+type SpanData struct {
+	Name        string
+	SpanContext SpanContext
+	// Parent is invalid for root spans.
+	Parent     SpanContext
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Errors     []error
+}
+
+// Exporter receives every span once it ended.
+type Exporter interface {
+	Export(span SpanData)
+}
+
+type tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a tracer passing its spans to exporter. A new span is a child of the span in ctx,
+// or of the remote span set by ContextWithRemote.
+func NewTracer(exporter Exporter) Tracer {
+	return &tracer{exporter: exporter}
+}
+
+type spanKey struct{}
+
+func (t *tracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &span{
+		exporter: t.exporter,
+		data: SpanData{
+			Name:       name,
+			Start:      time.Now(),
+			Attributes: make(map[string]interface{}),
+		},
+	}
+
+	if parent, _ := ctx.Value(spanKey{}).(*span); parent != nil {
+		s.data.Parent = parent.data.SpanContext
+	} else if remote, ok := RemoteFromContext(ctx); ok {
+		s.data.Parent = remote
+	}
+
+	if s.data.Parent.IsValid() {
+		s.data.SpanContext.TraceID = s.data.Parent.TraceID
+		s.data.SpanContext.Flags = s.data.Parent.Flags
+	} else {
+		rand.Read(s.data.SpanContext.TraceID[:])
+		s.data.SpanContext.Flags = 1
+	}
+	rand.Read(s.data.SpanContext.SpanID[:])
+
+	return context.WithValue(ContextWithoutRemote(ctx), spanKey{}, s), s
+}
+
+type span struct {
+	exporter Exporter
+	lock     sync.Mutex
+	data     SpanData
+	ended    bool
+}
+
+func (s *span) SetAttribute(key string, value interface{}) {
+	defer s.lock.Unlock()
+	s.lock.Lock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+func (s *span) RecordError(err error) {
+	defer s.lock.Unlock()
+	s.lock.Lock()
+	if !s.ended && err != nil {
+		s.data.Errors = append(s.data.Errors, err)
+	}
+}
+
+// End exports the span, only the first call has an effect.
+func (s *span) End() {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	s.lock.Unlock()
+
+	s.exporter.Export(s.data)
+}
+
+// SpanContextFromContext returns the span context of the span started by a tracer of NewTracer in ctx,
+// e.g. to propagate it to another process.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	if s == nil {
+		return SpanContext{}, false
+	}
+	return s.data.SpanContext, true
+}
+
+// InMemoryExporter keeps the exported spans in memory.
+type InMemoryExporter struct {
+	lock  sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(span SpanData) {
+	defer e.lock.Unlock()
+	e.lock.Lock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the exported spans in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	defer e.lock.Unlock()
+	e.lock.Lock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset removes the exported spans.
+func (e *InMemoryExporter) Reset() {
+	defer e.lock.Unlock()
+	e.lock.Lock()
+	e.spans = nil
+}
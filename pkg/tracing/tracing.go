@@ -0,0 +1,151 @@
+// Package tracing provides the tracer hook of eio and sio.
+//
+// Tracer and Span mirror the OpenTelemetry tracing API, the module github.com/adrianmxb/goseio/pkg/tracing/otel
+// adapts an OpenTelemetry tracer. Other adapters have to turn the span context of RemoteFromContext into the
+// parent of the new span.
+//
+// NewTracer implements the same span model without any dependencies, e.g. to test the instrumentation with an
+// InMemoryExporter.
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Tracer starts spans, the returned context carries the new span.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation started by a Tracer. Its methods may be called concurrently.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) RecordError(err error)                      {}
+func (nopSpan) End()                                       {}
+
+// Nop doesn't record anything.
+var Nop Tracer = nopTracer{}
+
+var errInvalidTraceParent = errors.New("invalid traceparent")
+
+// SpanContext identifies a span across processes, see https://www.w3.org/TR/trace-context/.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// IsValid reports whether trace and span id are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Sampled reports whether the sampled flag is set.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&1 != 0
+}
+
+// String returns the span context in the format of the traceparent header.
+func (sc SpanContext) String() string {
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" +
+		hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceParent parses the value of a traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func ParseTraceParent(traceParent string) (SpanContext, error) {
+	var sc SpanContext
+	if len(traceParent) < 55 || traceParent[2] != '-' || traceParent[35] != '-' || traceParent[52] != '-' {
+		return sc, errInvalidTraceParent
+	}
+	// later versions may append fields.
+	if traceParent[:2] == "ff" || (traceParent[:2] == "00" && len(traceParent) != 55) ||
+		(len(traceParent) > 55 && traceParent[55] != '-') {
+		return sc, errInvalidTraceParent
+	}
+
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(traceParent[:2])); err != nil {
+		return sc, errInvalidTraceParent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(traceParent[3:35])); err != nil {
+		return sc, errInvalidTraceParent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(traceParent[36:52])); err != nil {
+		return sc, errInvalidTraceParent
+	}
+	if _, err := hex.Decode(flags[:], []byte(traceParent[53:55])); err != nil {
+		return sc, errInvalidTraceParent
+	}
+	sc.Flags = flags[0]
+
+	if !sc.IsValid() {
+		return SpanContext{}, errInvalidTraceParent
+	}
+	return sc, nil
+}
+
+type remoteKey struct{}
+
+// ContextWithRemote returns a context whose next span is a child of the span sc of another process,
+// replacing the span of ctx.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(context.WithValue(ctx, spanKey{}, (*span)(nil)), remoteKey{}, sc)
+}
+
+// RemoteFromContext returns the span context set by ContextWithRemote.
+func RemoteFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// ContextWithoutRemote returns ctx without the span context set by ContextWithRemote, e.g. once a tracer
+// turned it into the parent of a span.
+func ContextWithoutRemote(ctx context.Context) context.Context {
+	if _, ok := RemoteFromContext(ctx); !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, nil)
+}
+
+// Extract returns a context with the remote span of traceParent, or ctx if it is empty or invalid.
+func Extract(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	sc, err := ParseTraceParent(traceParent)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemote(ctx, sc)
+}
+
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// Detach returns a context with the values of ctx that is never canceled, e.g. to keep the span of a request
+// as parent for the lifetime of a connection.
+func Detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
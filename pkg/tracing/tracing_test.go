@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	for traceParent, valid := range map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":         true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-later":   true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-invalid": false,
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":         false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01":         false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01":         false,
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01":         false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736":                             false,
+	} {
+		sc, err := ParseTraceParent(traceParent)
+		if (err == nil) != valid {
+			t.Errorf("%s: expected valid=%v, got %v", traceParent, valid, err)
+		}
+		if valid && traceParent[:2] == "00" && sc.String() != traceParent {
+			t.Errorf("%s: formatted as %s", traceParent, sc)
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	remote, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, parent := tracer.Start(Extract(context.Background(), remote.String()), "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.End()
+	parent.End()
+	parent.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name != "child" || spans[0].Attributes["key"] != "value" {
+		t.Fatalf("unexpected child %+v", spans[0])
+	}
+	if spans[1].Parent != remote || spans[0].Parent != spans[1].SpanContext {
+		t.Fatal("spans aren't linked to their parents")
+	}
+	if spans[0].SpanContext.TraceID != remote.TraceID {
+		t.Fatal("child doesn't belong to the remote trace")
+	}
+}